@@ -3,10 +3,12 @@ package main
 import (
 	"fmt"
 	"os"
+	"regexp"
 	"runtime"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	memoryStats "github.com/pbnjay/memory"
 	flag "github.com/spf13/pflag"
@@ -14,31 +16,80 @@ import (
 )
 
 type Args struct {
-	command        []string
-	data           []string
-	hasTripleColon bool
+	command []string
+	// every ":::", and every file after "::::", is a separate input source. So is stdin with -s
+	inputSources []inputSource
+	// files to split into parts with --pipepart
+	partedFiles []string
 }
 
 var (
+	flBlock                  = flag.String("block", "1M", "With --pipe or --pipepart, the `size` of input to pass to a single job, like 512k, 10M\nor 1G. Blocks get cut on record boundaries, so they end up a bit smaller or larger.")
+	flColsep                 = flag.String("colsep", "", "Split every argument into columns separated by a `regex`, available as {1}, {2}, and so on.")
+	flColsepFixed            = flag.Bool("colsep-fixed", false, "Treat the --colsep separator as a literal string instead of a regular expression.")
+	flCompress               = flag.Bool("compress", false, "Compress the output of jobs kept in memory while they wait for their turn, so that --max-mem\ngoes a lot further for repetitive output - at the cost of some CPU time.")
+	flContextReplace         = flag.BoolP("context-replace", "X", false, "Like -m, but repeat every word containing a placeholder for every argument.")
+	flCsv                    = flag.Bool("csv", false, "Split every argument into columns according to CSV quoting rules. The separator is ','\nunless --colsep says otherwise.")
+	flDelimiter              = flag.StringP("delimiter", "d", "\n", "The `delimiter` between arguments read from stdin or argument files. Can be more than one\ncharacter long, and understands escapes like \\t or \\0.")
 	flExecuteAndFlushTty     = flag.Bool("_execute-and-flush-tty", false, "Execute a given command and flush attached ttys afterwards. Used internally by gparallel.")
 	flFromStdin              = flag.BoolP("from-stdin", "s", false, "Get input from stdin.")
+	flHeader                 = flag.Bool("header", false, "Treat the first argument of every input source as a header naming its columns, and don't run\nit. Columns can then be referred to by name, like {version}.")
 	flHelp                   = flag.BoolP("help", "h", false, "Show this help message.")
+	flKeepEmpty              = flag.Bool("keep-empty", false, "Run the command for empty arguments read from stdin or argument files too, instead of\nskipping them.")
 	flKeepGoingOnError       = flag.Bool("keep-going-on-error", false, "Don't exit on error, keep going.")
+	flLineBuffer             = flag.Bool("line-buffer", false, "Print complete lines of output from every running job as soon as they arrive, instead of\nkeeping the output of whole jobs together and in order.")
+	flLink                   = flag.String("link", "", "Pair up arguments from every \":::\" one by one instead of running every combination of them.\nInput sources of different lengths are an error, unless `mode` is 'wrap' - then shorter ones start over.")
+	flMatch                  = flag.String("match", "", "Only run arguments matching a `regex`, and make its named groups available as placeholders:\n--match '(?P<major>\\d+)\\.(?P<minor>\\d+)' gives {major} and {minor}.")
+	flMaxArgs                = flag.IntP("max-args", "n", 0, "Pass up to `count` arguments to a single command, instead of just one.")
 	flMaxMemory              = flag.String("max-mem", "5%", "How much system `memory` can be used for storing command outputs before we start blocking.\nSet to 'inf' to disable the limit.")
+	flMaxOutputPerJob        = flag.String("max-output-per-job", "", "Keep at most this `size` of output of every job, like 100k or 10M, and drop the rest.")
+	flMaxOutputPolicy        = flag.String("max-output-policy", "head", "Which part of the output to keep with --max-output-per-job: 'head', 'tail', or 'both'.")
 	flMaxProcesses           = flag.IntP("max-concurrent", "P", max(runtime.NumCPU(), 1), "How many concurrent `children` to execute at once at maximum.\n(default based on the amount of cores)")
 	flMaxProcessesUpperLimit = flag.Int("max-concurrent-upper-limit", max(runtime.NumCPU(), 1), "The upper limit of maximum processes when inferring them from the number of CPUs.")
+	flMultipleArgs           = flag.BoolP("xargs", "m", false, "Pass as many arguments to a single command as fit in the maximum command line length.\nWords that are just a placeholder get repeated for every argument, other placeholders\nget replaced with values for all the arguments, separated by spaces.")
+	flNullDelimiter          = flag.BoolP("null", "0", false, "Arguments read from stdin or argument files are separated by NUL characters, like the output\nof find -print0. The same as -d '\\0'.")
+	flOutput                 = flag.String("output", "", "Write the stdout of every job into a file at a `path` made from the same placeholders\nas the command, instead of printing it: --output 'logs/{/.}.log'. Directories get created.")
+	flOutputStderr           = flag.String("output-stderr", "", "Like --output, but for stderr. Can be the same `path` as --output.")
+	flPipe                   = flag.Bool("pipe", false, "Split stdin into blocks of records and pass every block to the stdin of a separate job,\ninstead of using it for arguments.")
+	flPipepart               = flag.Bool("pipepart", false, "Like --pipe, but split up the files after \"::::\" instead of stdin. Every job reads its part\nstraight from the file, which is a lot faster for big ones.")
+	flQuietSuccess           = flag.Bool("quiet-success", false, "Only print the output of jobs that fail. With -v, successful jobs still get their command\nprinted. Every job's output is held on to until it exits - so without --spill, the oldest job still running\ncan keep storing output past --max-mem.")
 	flQueueCommandAncestor   = flag.String("queue-command-ancestor", "", "Queue a command for a specific ancestor process with a `name` to later execute with --wait.")
 	flQueueCommandParent     = flag.Bool("queue-command", false, "Queue a command for parent of gparellel to later execute with --wait.")
 	flQueueCommandPid        = flag.Int("queue-command-pid", -1, "Queue a command for a specific ancestor `pid` to let it later execute it with --wait.")
 	flQueueWait              = flag.Bool("wait", false, "Execute and wait for commands queued using --queue-*.")
+	flRecend                 = flag.String("recend", "\n", "With --pipe or --pipepart, the `string` every record ends with. Understands escapes like \\t or \\0.")
+	flRecstart               = flag.String("recstart", "", "With --pipe or --pipepart, the `string` every record starts with. Understands escapes like \\t or \\0.")
 	flRecursiveProcessLimit  = flag.Bool("recursive-max-concurrent", true, "Whether to apply the one -P children limit to all gparallel subprocesses as well as a shared\nresource.")
+	flResults                = flag.String("results", "", "Also save the output of every job into a `directory`: DIR/<job number>/stdout and stderr,\nalong with cmd, exitcode and duration in seconds.")
+	flShell                  = flag.String("shell", "", "Run commands through a `shell`, with every value put in place of a placeholder quoted\nfor it. The command can then use pipes, redirections or exported shell functions:\ngparallel --shell 'grep x {} | wc -l' ::: files.")
 	flShowQueue              = flag.Bool("show-queue", false, "Show every queued command for every process - useful for debugging missing --wait calls.")
 	flSlurpStdin             = flag.Bool("slurp-stdin", false, "Read all available stdin and pass it onto the command - only works in the --queue-command-* mode.\n(as otherwise it would send everything to the first command).")
-	flTemplate               = flag.StringP("replacement", "I", "{}", "The `replacement` string.")
+	flSpill                  = flag.Bool("spill", false, "Instead of blocking jobs once --max-mem is reached, save the rest of their output into\ntemporary files.")
+	flSummary                = flag.Int("summary", -1, "Once every job is done, print how many of them succeeded, failed or weren't run to completion\nafter a failure, the `count` slowest ones (5 unless given as --summary=count), the command and exit code\nof every failed one, and how much output --max-output-per-job dropped.")
+	flTag                    = flag.Bool("tag", false, "Prefix every line of output with the arguments of the job it comes from, and a tab.")
+	flTagString              = flag.String("tag-string", "", "Prefix every line of output with a `template` and a tab, instead of just the arguments.\nIt can use the same placeholders as the command, and {elapsed} for the time the job's been\nrunning for: --tag-string '{#} {elapsed}'.")
+	flTee                    = flag.Bool("tee", false, "Pass a copy of the whole stdin to every job. They all run at once, so -P has to allow for\nthat, and their output over --max-mem goes to temporary files like with --spill. Without a command,\nevery argument is a shell command of its own:\nproducer | gparallel --tee ::: 'analyzer-a --fast' analyzer-b.")
+	flTemplate               = flag.StringP("replacement", "I", "{}", "The `replacement` string. Its two halves also delimit the other placeholders:\n{1}, {2}, ... for arguments from the first, second, ... \":::\" (or columns with --colsep),\n{.} without the extension, {/} for the basename, {//} for the dirname, {/.} for both,\nand combinations like {2/.}. {#} is the job number, {%} its slot from 1 to -P,\nand {##} the number of all jobs. Values can also be passed through functions, as in\n{upper}, {2|lower}, {q}, {json}, {replace:from:to} or {regex:pattern}.")
+	flTmpdir                 = flag.String("tmpdir", "", "The `directory` to put temporary files for --spill in. (default: $TMPDIR, or /tmp if it's not set)")
+	flUnordered              = flag.Bool("unordered", false, "Print the output of every job as soon as it finishes, instead of in the order of arguments.")
 	flVerbose                = flag.BoolP("verbose", "v", false, "Print the full command line before each execution.")
 	flVersion                = flag.Bool("version", false, "Show the program version.")
+	flWarnUnmatched          = flag.Bool("warn-unmatched", false, "Print a warning for every argument skipped because it doesn't match --match.")
 
 	parsedFlMaxMemory int64
+	parsedFlDelimiter string
+	// nil without --colsep
+	parsedFlColsep       *regexp.Regexp
+	parsedFlCsvSeparator rune
+	// nil without --match
+	parsedFlMatch *regexp.Regexp
+	// empty without --shell
+	parsedFlShell     string
+	parsedFlBlockSize int
+	parsedFlRecend    string
+	parsedFlRecstart  string
+	// 0 without --max-output-per-job
+	parsedFlMaxOutputPerJob int64
 )
 
 func showVersion() {
@@ -85,8 +136,11 @@ func countTrue(values ...bool) (i int) {
 }
 
 func usage() {
-	_, _ = fmt.Fprintf(os.Stderr, "Usage: %s    [-v] [-P proc] [-I replacement] command [arguments] ::: arguments\n", os.Args[0])
+	_, _ = fmt.Fprintf(os.Stderr, "Usage: %s    [-v] [-P proc] [-I replacement] command [arguments] ::: arguments [::: arguments]...\n", os.Args[0])
+	_, _ = fmt.Fprintf(os.Stderr, "       %s    [-v] [-P proc] [-I replacement] command [arguments] :::: argument-files [::: arguments]...\n", os.Args[0])
 	_, _ = fmt.Fprintf(os.Stderr, "       %s -s [-v] [-P proc] [-I replacement] command [arguments] < arguments-in-lines\n", os.Args[0])
+	_, _ = fmt.Fprintf(os.Stderr, "       %s --pipe [--block size] [-v] [-P proc] command [arguments] < input\n", os.Args[0])
+	_, _ = fmt.Fprintf(os.Stderr, "       %s --pipepart [--block size] [-v] [-P proc] command [arguments] :::: files\n", os.Args[0])
 	_, _ = fmt.Fprintf(os.Stderr, "       %s --wait\n", os.Args[0])
 	_, _ = fmt.Fprintf(os.Stderr, "       %s --queue-command command [arguments]\n", os.Args[0])
 	_, _ = fmt.Fprintf(os.Stderr, "       %s --queue-command-pid pid command [arguments]\n", os.Args[0])
@@ -100,7 +154,7 @@ func exitWithUsage(exitCode int) {
 }
 
 func errorWithUsage(format string, args ...any) {
-	_, _ = fmt.Fprintf(os.Stderr, "%s: Argument error: "+format+"\n\n", append([]any{os.Args[0]}, args...))
+	_, _ = fmt.Fprintf(os.Stderr, "%s: Argument error: "+format+"\n\n", append([]any{os.Args[0]}, args...)...)
 	exitWithUsage(1)
 }
 
@@ -108,6 +162,9 @@ func parseArgs() Args {
 	flag.Usage = usage
 	flag.SetInterspersed(false)
 	_ = flag.CommandLine.MarkHidden("_execute-and-flush-tty")
+	flag.Lookup("link").NoOptDefVal = "strict"
+	flag.Lookup("shell").NoOptDefVal = "$SHELL"
+	flag.Lookup("summary").NoOptDefVal = "5"
 	flag.Parse()
 
 	if *flVersion {
@@ -120,7 +177,16 @@ func parseArgs() Args {
 	}
 
 	parsedFlMaxMemory = maxMemoryFromFlag()
-	*flMaxProcesses = min(*flMaxProcesses, *flMaxProcessesUpperLimit)
+	parsedFlDelimiter = delimiterFromFlags()
+	parsedFlColsep, parsedFlCsvSeparator = columnSeparatorFromFlags()
+	parsedFlMatch = matchFromFlags()
+	parsedFlShell = shellFromFlag()
+	parsedFlBlockSize, parsedFlRecend, parsedFlRecstart = pipeFromFlags()
+	parsedFlMaxOutputPerJob = maxOutputPerJobFromFlags()
+	if !*flTee || !flag.CommandLine.Changed("max-concurrent") {
+		// --tee has to run every job at once, so an explicit -P has to be able to go over the limit for it
+		*flMaxProcesses = min(*flMaxProcesses, *flMaxProcessesUpperLimit)
+	}
 
 	args := flag.Args()
 
@@ -133,6 +199,8 @@ func parseArgs() Args {
 
 	exclusiveFlags := flagsPreventingFurtherArguments + countTrue(
 		*flFromStdin,
+		*flPipe,
+		*flPipepart,
 		*flExecuteAndFlushTty,
 		queueModeEnabled,
 	)
@@ -145,9 +213,15 @@ func parseArgs() Args {
 		errorWithUsage("-P (--max-concurrent) cannot be less than 1")
 	}
 
+	if *flMaxArgs < 0 {
+		errorWithUsage("-n (--max-args) cannot be negative")
+	}
+
 	if exclusiveFlags > 1 {
-		errorWithUsage("Cannot specify %v, %v, %v, %v, and %v (or %v, or %v) at the same time",
+		errorWithUsage("Cannot specify %v, %v, %v, %v, %v, %v, and %v (or %v, or %v) at the same time",
 			"--from-stdin",
+			"--pipe",
+			"--pipepart",
 			"--_execute-and-flush-tty",
 			"--wait",
 			"--show-queue",
@@ -156,6 +230,26 @@ func parseArgs() Args {
 			"--queue-command-pid")
 	}
 
+	if *flLink != "" && *flLink != "strict" && *flLink != "wrap" {
+		errorWithUsage("the --link flag only accepts 'strict' and 'wrap' as values, but got '%s'", *flLink)
+	}
+
+	if *flTee && (*flFromStdin || *flPipe || *flPipepart) {
+		errorWithUsage("--tee passes stdin to every job, it cannot be used with -s (--from-stdin), --pipe or --pipepart")
+	}
+
+	if flag.CommandLine.Changed("summary") && *flSummary < 0 {
+		errorWithUsage("--summary needs a number of slowest jobs to show that's not negative, got %d", *flSummary)
+	}
+
+	if *flQuietSuccess && *flLineBuffer {
+		errorWithUsage("--line-buffer prints output before jobs exit, it cannot be used with --quiet-success")
+	}
+
+	if *flTmpdir != "" && !*flSpill && !*flTee {
+		errorWithUsage("The --tmpdir flag only makes sense along with --spill or --tee")
+	}
+
 	if *flSlurpStdin && !queueModeEnabled {
 		errorWithUsage("The --slurp-stdin flag can only be specified with %s, %s, or %s",
 			"--queue-command",
@@ -166,28 +260,319 @@ func parseArgs() Args {
 	subcommandSupportsTripleColon := exclusiveFlags < 1
 
 	if subcommandSupportsTripleColon {
-		threeColons := slices.Index(args, ":::")
-		foundTripleColon := threeColons != -1
+		firstSeparator := slices.IndexFunc(args, isInputSourceSeparator)
+		foundTripleColon := firstSeparator != -1
 
 		if !*flFromStdin && !foundTripleColon {
 			errorWithUsage("don't know where to get arguments from: neither -s (--from-stdin) nor \":::\" specified in the arguments")
 		}
 
+		if *flTee && firstSeparator == 0 && parsedFlShell == "" {
+			// without a command, every argument is a whole analyzer command of its own
+			parsedFlShell = "/bin/sh"
+		}
+
 		if foundTripleColon {
+			inputSources := splitInputSources(args[firstSeparator:])
+			checkLinkedListLengths(inputSources)
+
 			return Args{
-				command:        args[0:threeColons],
-				data:           args[threeColons+1:],
-				hasTripleColon: true,
+				command:      args[0:firstSeparator],
+				inputSources: withMatches(withHeaders(inputSources)),
 			}
 		}
 	}
 
+	if *flPipe && slices.IndexFunc(args, isInputSourceSeparator) != -1 {
+		errorWithUsage("--pipe reads its input from stdin, it cannot be used with \":::\" or \"::::\"")
+	}
+
+	if *flPipepart {
+		separator := slices.IndexFunc(args, isInputSourceSeparator)
+		if separator == -1 || args[separator] != "::::" || separator == len(args)-1 {
+			errorWithUsage("--pipepart needs the files to split up after \"::::\"")
+		}
+		if slices.IndexFunc(args[separator+1:], isInputSourceSeparator) != -1 {
+			errorWithUsage("--pipepart only takes files after a single \"::::\"")
+		}
+
+		return Args{
+			command:     args[:separator],
+			partedFiles: args[separator+1:],
+		}
+	}
+
+	if *flFromStdin {
+		return Args{
+			command:      args,
+			inputSources: withMatches(withHeaders([]inputSource{newStreamedInputSource("stdin", os.Stdin)})),
+		}
+	}
+
 	return Args{
 		command: args,
-		data:    []string{},
 	}
 }
 
+func isInputSourceSeparator(arg string) bool {
+	return arg == ":::" || arg == "::::"
+}
+
+// splitInputSources turns everything from the first ":::" or "::::" onwards into separate input sources - one
+// for every ":::", and one for every file after a "::::"
+func splitInputSources(args []string) (sources []inputSource) {
+	for len(args) > 0 {
+		separator := args[0]
+		args = args[1:]
+
+		end := slices.IndexFunc(args, isInputSourceSeparator)
+		if end == -1 {
+			end = len(args)
+		}
+
+		if separator == ":::" {
+			sources = append(sources, &argumentList{arguments: args[:end]})
+		} else {
+			if end == 0 {
+				errorWithUsage("\"::::\" needs to be followed by at least one file name (or - for stdin)")
+			}
+			for _, fileName := range args[:end] {
+				sources = append(sources, openArgumentFile(fileName))
+			}
+		}
+
+		args = args[end:]
+	}
+
+	return sources
+}
+
+// checkLinkedListLengths makes sure that --link can pair up the arguments given after ":::" before any job starts.
+// With --match, the lengths only matter after filtering, so they have to be checked as the jobs go
+func checkLinkedListLengths(sources []inputSource) {
+	if *flLink != "strict" || *flMatch != "" {
+		return
+	}
+
+	length := -1
+	for _, source := range sources {
+		list, ok := source.(*argumentList)
+		if !ok {
+			continue
+		}
+		if length != -1 && len(list.arguments) != length {
+			errorWithUsage("cannot --link input sources of different lengths: one has %d arguments and another %d "+
+				"(use --link=wrap to start shorter ones over)", length, len(list.arguments))
+		}
+		length = len(list.arguments)
+	}
+}
+
+func delimiterFromFlags() string {
+	if *flNullDelimiter {
+		if flag.CommandLine.Changed("delimiter") {
+			errorWithUsage("Cannot specify both -0 (--null) and -d (--delimiter) at the same time")
+		}
+		return "\x00"
+	}
+
+	delimiter, err := unescape(*flDelimiter)
+	if err != nil {
+		errorWithUsage("Invalid value of the --delimiter flag: %v", err)
+	}
+	if delimiter == "" {
+		errorWithUsage("The --delimiter flag cannot be empty")
+	}
+
+	return delimiter
+}
+
+func columnSeparatorFromFlags() (colsep *regexp.Regexp, csvSeparator rune) {
+	if *flCsv {
+		if flag.CommandLine.Changed("delimiter") || *flNullDelimiter {
+			errorWithUsage("CSV records are always separated by newlines, --csv cannot be used with -d (--delimiter) or -0 (--null)")
+		}
+		if *flColsep == "" {
+			return nil, ','
+		}
+
+		separator, err := unescape(*flColsep)
+		if err != nil {
+			errorWithUsage("Invalid value of the --colsep flag: %v", err)
+		}
+		if utf8.RuneCountInString(separator) != 1 || separator == "\"" || separator == "\n" || separator == "\r" {
+			errorWithUsage("With --csv, --colsep has to be a single character other than a quote or a newline, but got '%s'", *flColsep)
+		}
+
+		return nil, []rune(separator)[0]
+	}
+
+	if *flColsep == "" {
+		if *flColsepFixed {
+			errorWithUsage("The --colsep-fixed flag only makes sense along with --colsep")
+		}
+		return nil, 0
+	}
+
+	pattern := *flColsep
+	if *flColsepFixed {
+		separator, err := unescape(*flColsep)
+		if err != nil {
+			errorWithUsage("Invalid value of the --colsep flag: %v", err)
+		}
+		pattern = regexp.QuoteMeta(separator)
+	}
+
+	colsep, err := regexp.Compile(pattern)
+	if err != nil {
+		errorWithUsage("Invalid regular expression in the --colsep flag: %v", err)
+	}
+
+	return colsep, 0
+}
+
+func matchFromFlags() *regexp.Regexp {
+	if *flMatch == "" {
+		if *flWarnUnmatched {
+			errorWithUsage("The --warn-unmatched flag only makes sense along with --match")
+		}
+		return nil
+	}
+
+	match, err := regexp.Compile(*flMatch)
+	if err != nil {
+		errorWithUsage("Invalid regular expression in the --match flag: %v", err)
+	}
+
+	return match
+}
+
+func shellFromFlag() string {
+	if *flShell != "$SHELL" {
+		return *flShell
+	}
+
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	return "/bin/sh"
+}
+
+func pipeFromFlags() (blockSize int, recend string, recstart string) {
+	if !*flPipe && !*flPipepart {
+		if flag.CommandLine.Changed("block") || flag.CommandLine.Changed("recend") || flag.CommandLine.Changed("recstart") {
+			errorWithUsage("The --block, --recend and --recstart flags only make sense along with --pipe or --pipepart")
+		}
+		return 0, "", ""
+	}
+
+	blockSize, err := parseSize(*flBlock)
+	if err != nil {
+		errorWithUsage("Invalid value of the --block flag: %v", err)
+	}
+	if blockSize < 1 {
+		errorWithUsage("The --block flag has to be at least 1 byte")
+	}
+
+	recend, err = unescape(*flRecend)
+	if err != nil {
+		errorWithUsage("Invalid value of the --recend flag: %v", err)
+	}
+	recstart, err = unescape(*flRecstart)
+	if err != nil {
+		errorWithUsage("Invalid value of the --recstart flag: %v", err)
+	}
+
+	// records that are only known by how they start don't have to end with a newline
+	if flag.CommandLine.Changed("recstart") && !flag.CommandLine.Changed("recend") {
+		recend = ""
+	}
+	if recend == "" && recstart == "" {
+		errorWithUsage("The --recend and --recstart flags cannot both be empty")
+	}
+
+	return blockSize, recend, recstart
+}
+
+func maxOutputPerJobFromFlags() int64 {
+	if *flMaxOutputPolicy != "head" && *flMaxOutputPolicy != "tail" && *flMaxOutputPolicy != "both" {
+		errorWithUsage("the --max-output-policy flag only accepts 'head', 'tail' and 'both' as values, but got '%s'", *flMaxOutputPolicy)
+	}
+
+	if *flMaxOutputPerJob == "" {
+		if flag.CommandLine.Changed("max-output-policy") {
+			errorWithUsage("The --max-output-policy flag only makes sense along with --max-output-per-job")
+		}
+		return 0
+	}
+
+	size, err := parseSize(*flMaxOutputPerJob)
+	if err != nil {
+		errorWithUsage("Invalid value of the --max-output-per-job flag: %v", err)
+	}
+	if size < 1 {
+		errorWithUsage("The --max-output-per-job flag has to be at least 1 byte")
+	}
+
+	return int64(size)
+}
+
+// parseSize understands sizes like 100, 512k, 10M or 1G - with binary multipliers, like dd does
+func parseSize(value string) (int, error) {
+	multiplier := 1
+	number := strings.TrimSuffix(strings.TrimSuffix(value, "B"), "b")
+
+	if number != "" {
+		switch number[len(number)-1] {
+		case 'k', 'K':
+			multiplier = 1 << 10
+		case 'm', 'M':
+			multiplier = 1 << 20
+		case 'g', 'G':
+			multiplier = 1 << 30
+		case 't', 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier != 1 {
+			number = number[:len(number)-1]
+		}
+	}
+
+	size, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not a size like 100, 512k, 10M or 1G", value)
+	}
+	return int(size * float64(multiplier)), nil
+}
+
+// unescape interprets backslash escapes the way Go (and mostly C) string literals do, with the addition of a
+// lone \0 meaning the NUL character - so that a user can type -d '\0' or -d '\t'
+func unescape(value string) (string, error) {
+	var builder strings.Builder
+
+	for len(value) > 0 {
+		if strings.HasPrefix(value, "\\0") && (len(value) == 2 || value[2] < '0' || value[2] > '7') {
+			builder.WriteByte(0)
+			value = value[2:]
+			continue
+		}
+
+		char, multibyte, tail, err := strconv.UnquoteChar(value, 0)
+		if err != nil {
+			return "", fmt.Errorf("cannot unescape '%s': %w", value, err)
+		}
+
+		if multibyte {
+			builder.WriteRune(char)
+		} else {
+			builder.WriteByte(byte(char))
+		}
+		value = tail
+	}
+
+	return builder.String(), nil
+}
+
 func maxMemoryFromFlag() int64 {
 	totalMemory := memoryStats.TotalMemory()
 
@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"plain", "plain", false},
+		{"\\n", "\n", false},
+		{"\\t|\\r\\n", "\t|\r\n", false},
+		{"\\0", "\x00", false},
+		{"a\\0b", "a\x00b", false},
+		{"\\012", "\n", false},
+		{"\\x41\\u00e9", "Aé", false},
+		{"\\\\", "\\", false},
+		{"zażółć", "zażółć", false},
+		{"", "", false},
+		{"\\", "", true},
+		{"\\q", "", true},
+	}
+
+	for _, test := range tests {
+		got, err := unescape(test.value)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("unescape(%q) = %q, %v; want %q, error: %v", test.value, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"100", 100, false},
+		{"512k", 512 * 1024, false},
+		{"512K", 512 * 1024, false},
+		{"10M", 10 * 1024 * 1024, false},
+		{"10MB", 10 * 1024 * 1024, false},
+		{"10mb", 10 * 1024 * 1024, false},
+		{"1G", 1024 * 1024 * 1024, false},
+		{"1.5k", 1536, false},
+		{"100B", 100, false},
+		{"", 0, true},
+		{"k", 0, true},
+		{"ten", 0, true},
+		{"10X", 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := parseSize(test.value)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d, error: %v", test.value, got, err, test.want, test.wantErr)
+		}
+	}
+}
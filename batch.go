@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const pointerSize = int(unsafe.Sizeof(uintptr(0)))
+
+// maxCommandLineLength estimates how long the arguments of a command can get before exec fails with E2BIG, for -m
+// and -X. It doesn't have to be exact - runBatch splits up commands that turn out to be too long anyway
+var maxCommandLineLength = onceValue(func() int {
+	argMax := 256 * 1024
+
+	if runtime.GOOS == "linux" {
+		// Linux allows a quarter of the stack size limit to be taken up by arguments and environment variables,
+		// up to 6 MiB
+		var stack syscall.Rlimit
+		if err := syscall.Getrlimit(syscall.RLIMIT_STACK, &stack); err == nil {
+			argMax = max(argMax, min(int(stack.Cur/4), 6*1024*1024))
+		}
+	}
+
+	environment := 0
+	for _, variable := range os.Environ() {
+		environment += len(variable) + 1 + pointerSize
+	}
+
+	// leave some room for what gets added on the way, like the --_execute-and-flush-tty wrapper
+	return argMax - environment - 4096
+})
+
+// maxWordLength is how long a single word of a command line can get. Linux has a limit for that on top of the one
+// for all of them together (MAX_ARG_STRLEN), which matters for words that all the arguments of a batch get joined
+// into
+var maxWordLength = onceValue(func() int {
+	if runtime.GOOS == "linux" {
+		return 32 * 4096
+	}
+	return maxCommandLineLength()
+})
+
+// joinedWords returns the lengths of words of a command that arguments can get joined into: with --shell, the whole
+// script is a single one, otherwise it's the words themselves
+func joinedWords(command []string) (lengths []int) {
+	if parsedFlShell != "" {
+		return []int{commandLength(command)}
+	}
+
+	lengths = make([]int, len(command))
+	for i, word := range command {
+		lengths[i] = len(word)
+	}
+	return lengths
+}
+
+// joinedWordsLength estimates how much a set of arguments adds to every one of joinedWords. With -m, that's only
+// the words with something else besides placeholders in them - the rest get repeated for every argument instead
+func joinedWordsLength(command []string, arguments jobArguments) (lengths []int) {
+	if parsedFlShell != "" {
+		return []int{argumentsLength(command, arguments)}
+	}
+
+	lengths = make([]int, len(command))
+	if *flContextReplace {
+		return lengths
+	}
+	for i, word := range command {
+		replaced, replacedAny := replaceArgumentPlaceholders(word, arguments)
+		if onlyPlaceholders, dependsOnArguments := classifyWord(word, arguments); replacedAny && dependsOnArguments && !onlyPlaceholders {
+			// too much by whatever's around the placeholders, as it only appears once, which is fine
+			lengths[i] = len(replaced) + 1
+		}
+	}
+	return lengths
+}
+
+// argumentsLength estimates how much a set of arguments adds to the length of a command line it's batched into
+func argumentsLength(command []string, arguments jobArguments) (length int) {
+	replacedIn := 0
+
+	for _, word := range command {
+		replaced, replacedAny := replaceArgumentPlaceholders(word, arguments)
+		if replacedAny {
+			// slightly too much when placeholders get joined with others in a single word instead of repeated,
+			// which is fine
+			length += len(replaced) + 1 + pointerSize
+			replacedIn += 1
+		}
+	}
+
+	if replacedIn == 0 || *flTemplate == "" {
+		for _, column := range arguments.columns {
+			length += len(column) + 1 + pointerSize
+		}
+	}
+
+	return length
+}
+
+func commandLength(command []string) (length int) {
+	for _, word := range command {
+		length += len(word) + 1 + pointerSize
+	}
+	return length
+}
+
+// batchArguments groups sets of arguments into batches to run a single command for: of up to -n of them, and of
+// as many as fit in a command line with -m or -X. Without any of those flags, every batch has just one set
+func batchArguments(command []string, forEachArguments func(fn func(arguments jobArguments) (keepGoing bool)), fn func(batch []jobArguments) (keepGoing bool)) {
+	maxBatch := *flMaxArgs
+	if maxBatch == 0 && !*flMultipleArgs && !*flContextReplace {
+		maxBatch = 1
+	}
+
+	var batch []jobArguments
+	batchLength := commandLength(command)
+	batchWords := joinedWords(command)
+	keepGoing := true
+
+	forEachArguments(func(arguments jobArguments) bool {
+		length := 0
+		var wordsLength []int
+		if *flMultipleArgs || *flContextReplace {
+			length = argumentsLength(command, arguments)
+			wordsLength = joinedWordsLength(command, arguments)
+		}
+
+		full := maxBatch > 0 && len(batch) >= maxBatch
+		tooLong := batchLength+length > maxCommandLineLength()
+		for i := range wordsLength {
+			tooLong = tooLong || batchWords[i]+wordsLength[i] > maxWordLength()
+		}
+		if len(batch) > 0 && (full || ((*flMultipleArgs || *flContextReplace) && tooLong)) {
+			keepGoing = fn(batch)
+			if !keepGoing {
+				return false
+			}
+
+			// don't reuse the slice, fn is allowed to hold on to it
+			batch, batchLength, batchWords = nil, commandLength(command), joinedWords(command)
+		}
+
+		batch = append(batch, arguments)
+		batchLength += length
+		for i := range wordsLength {
+			batchWords[i] += wordsLength[i]
+		}
+		return true
+	})
+
+	if keepGoing && len(batch) > 0 {
+		fn(batch)
+	}
+}
+
+// runBatch runs a command for a batch of arguments - and if it turns out to be too long to run, splits the batch in
+// half and tries again with both halves
+func runBatch(command []string, batch []jobArguments, sequence *int, total int, result chan<- *ProcessResult) (keepGoing bool) {
+	if noLongerSpawnChildren.Load() {
+		return summary.jobsNotStarted(1)
+	}
+
+	process, err := runInSlot(func(slot int) job {
+		for i := range batch {
+			batch[i].sequence, batch[i].slot, batch[i].total = *sequence+1, slot, total
+		}
+		return newJob(command, batch)
+	}, nil)
+
+	if errors.Is(err, syscall.E2BIG) && len(batch) > 1 {
+		half := len(batch) / 2
+		return runBatch(command, batch[:half], sequence, total, result) &&
+			runBatch(command, batch[half:], sequence, total, result)
+	}
+	if err != nil {
+		log.Fatalf("Could not start %v\n", err)
+	}
+
+	*sequence += 1
+	result <- process
+	return true
+}
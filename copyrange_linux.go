@@ -0,0 +1,38 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// copyFileRange copies length bytes of a file starting at offset into a pipe with splice(2), so that the data never
+// leaves the kernel
+func copyFileRange(pipe *os.File, file *os.File, offset int64, length int64) error {
+	// Fd() puts the pipe into blocking mode, which is what we want - every part gets its own goroutine anyway
+	pipeFd, fileFd := int(pipe.Fd()), int(file.Fd())
+
+	for length > 0 {
+		n, err := syscall.Splice(fileFd, &offset, pipeFd, nil, min(int(length), 1<<30), 0)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if errors.Is(err, syscall.EINVAL) {
+			// the filesystem doesn't support splicing
+			_, err = io.Copy(pipe, io.NewSectionReader(file, offset, length))
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		length -= n
+	}
+
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// copyFileRange copies length bytes of a file starting at offset into a pipe
+func copyFileRange(pipe *os.File, file *os.File, offset int64, length int64) error {
+	_, err := io.Copy(pipe, io.NewSectionReader(file, offset, length))
+	return err
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// inputArgument is a single argument from an input source, along with its columns
+type inputArgument struct {
+	whole string
+	// the argument split by --colsep or --csv - or just the whole argument if neither of them is used
+	columns []string
+	// names of the columns, from the header of the input source with --header
+	columnNames []string
+	// named groups of the --match regex
+	matchedGroups map[string]string
+}
+
+// inputSource yields arguments one by one - either straight from the command line, or lazily read from a file
+type inputSource interface {
+	next() (argument inputArgument, ok bool)
+}
+
+func splitIntoColumns(argument string) inputArgument {
+	switch {
+	case *flCsv:
+		reader := newCsvReader(strings.NewReader(argument))
+		columns, err := reader.Read()
+		if err == io.EOF {
+			columns = []string{""}
+		} else if err != nil {
+			log.Fatalf("Could not parse '%s' as CSV: %v\n", argument, err)
+		}
+		return inputArgument{whole: argument, columns: columns}
+	case parsedFlColsep != nil:
+		return inputArgument{whole: argument, columns: parsedFlColsep.Split(argument, -1)}
+	default:
+		return inputArgument{whole: argument, columns: []string{argument}}
+	}
+}
+
+// argumentList is an input source given directly in the command line, after a ":::"
+type argumentList struct {
+	arguments []string
+}
+
+func (list *argumentList) next() (argument inputArgument, ok bool) {
+	if len(list.arguments) == 0 {
+		return inputArgument{}, false
+	}
+
+	argument, list.arguments = splitIntoColumns(list.arguments[0]), list.arguments[1:]
+	return argument, true
+}
+
+// recordReader is an input source streamed from a file or stdin, one record at a time - records being
+// separated by newlines, or whatever --delimiter says
+type recordReader struct {
+	name   string
+	reader *bufio.Reader
+	closer io.Closer
+}
+
+// newStreamedInputSource reads arguments from a file or stdin - as CSV with --csv, or as delimited records
+func newStreamedInputSource(name string, file io.ReadCloser) inputSource {
+	if *flCsv {
+		return &csvRecordReader{
+			name:   name,
+			reader: newCsvReader(file),
+			closer: file,
+		}
+	}
+
+	return &recordReader{
+		name:   name,
+		reader: bufio.NewReader(file),
+		closer: file,
+	}
+}
+
+func openArgumentFile(fileName string) inputSource {
+	if fileName == "-" {
+		return newStreamedInputSource("stdin", os.Stdin)
+	}
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		log.Fatalf("Could not open argument file: %v\n", err)
+	}
+
+	return newStreamedInputSource(fileName, file)
+}
+
+// readRecord reads everything up to the next delimiter, which can be longer than one byte
+func (records *recordReader) readRecord() (record string, err error) {
+	var builder strings.Builder
+	delimiterEnd := parsedFlDelimiter[len(parsedFlDelimiter)-1]
+
+	for {
+		part, err := records.reader.ReadString(delimiterEnd)
+		builder.WriteString(part)
+
+		if err != nil || strings.HasSuffix(builder.String(), parsedFlDelimiter) {
+			return strings.TrimSuffix(builder.String(), parsedFlDelimiter), err
+		}
+	}
+}
+
+func (records *recordReader) next() (argument inputArgument, ok bool) {
+	for records.reader != nil {
+		record, err := records.readRecord()
+
+		if err == io.EOF {
+			haveToClose(records.name, records.closer)
+			records.reader = nil
+
+			// there's no record after the last delimiter, even with --keep-empty
+			if len(record) == 0 {
+				break
+			}
+		} else if err != nil {
+			log.Fatalf("Failed reading %s: %v\n", records.name, err)
+		}
+
+		if len(record) > 0 || *flKeepEmpty {
+			return splitIntoColumns(record), true
+		}
+	}
+
+	return inputArgument{}, false
+}
+
+func newCsvReader(reader io.Reader) *csv.Reader {
+	csvReader := csv.NewReader(reader)
+	csvReader.Comma = parsedFlCsvSeparator
+	csvReader.FieldsPerRecord = -1
+	return csvReader
+}
+
+// csvRecordReader is an input source streamed from a CSV file (or stdin) with --csv. It doesn't use --delimiter, as
+// CSV fields can have quoted newlines inside them
+type csvRecordReader struct {
+	name   string
+	reader *csv.Reader
+	closer io.Closer
+}
+
+func (records *csvRecordReader) next() (argument inputArgument, ok bool) {
+	if records.reader == nil {
+		return inputArgument{}, false
+	}
+
+	columns, err := records.reader.Read()
+	if err == io.EOF {
+		haveToClose(records.name, records.closer)
+		records.reader = nil
+		return inputArgument{}, false
+	} else if err != nil {
+		log.Fatalf("Failed reading %s as CSV: %v\n", records.name, err)
+	}
+
+	return inputArgument{whole: joinCsvRecord(columns), columns: columns}, true
+}
+
+// joinCsvRecord puts a parsed CSV record back together, so that {} can still mean the whole record
+func joinCsvRecord(columns []string) string {
+	var builder strings.Builder
+
+	writer := csv.NewWriter(&builder)
+	writer.Comma = parsedFlCsvSeparator
+	_ = writer.Write(columns)
+	writer.Flush()
+
+	return strings.TrimSuffix(builder.String(), "\n")
+}
+
+// headerSource takes the first argument of an input source as names for the columns of all the other ones
+type headerSource struct {
+	inputSource
+	columnNames []string
+	readHeader  bool
+}
+
+func (source *headerSource) next() (argument inputArgument, ok bool) {
+	if !source.readHeader {
+		header, ok := source.inputSource.next()
+		if !ok {
+			return inputArgument{}, false
+		}
+		source.columnNames = header.columns
+		source.readHeader = true
+	}
+
+	argument, ok = source.inputSource.next()
+	argument.columnNames = source.columnNames
+	return argument, ok
+}
+
+// withHeaders makes every input source start with a header with --header
+func withHeaders(sources []inputSource) []inputSource {
+	if !*flHeader {
+		return sources
+	}
+
+	for i, source := range sources {
+		sources[i] = &headerSource{inputSource: source}
+	}
+	return sources
+}
+
+// matchingSource skips arguments not matching --match, and captures its named groups from the ones that do
+type matchingSource struct {
+	inputSource
+}
+
+func (source matchingSource) next() (argument inputArgument, ok bool) {
+	for {
+		argument, ok = source.inputSource.next()
+		if !ok {
+			return inputArgument{}, false
+		}
+
+		match := parsedFlMatch.FindStringSubmatch(argument.whole)
+		if match == nil {
+			if *flWarnUnmatched {
+				_, _ = fmt.Fprintf(os.Stderr, "%s: Warning: skipping '%s', as it doesn't match --match\n", os.Args[0], argument.whole)
+			}
+			continue
+		}
+
+		argument.matchedGroups = make(map[string]string)
+		for i, name := range parsedFlMatch.SubexpNames() {
+			if name != "" {
+				argument.matchedGroups[name] = match[i]
+			}
+		}
+		return argument, true
+	}
+}
+
+// withMatches filters every input source through --match
+func withMatches(sources []inputSource) []inputSource {
+	if parsedFlMatch == nil {
+		return sources
+	}
+
+	for i, source := range sources {
+		sources[i] = matchingSource{source}
+	}
+	return sources
+}
+
+func readAllArguments(source inputSource) (arguments []inputArgument) {
+	for {
+		argument, ok := source.next()
+		if !ok {
+			return arguments
+		}
+		arguments = append(arguments, argument)
+	}
+}
+
+// cartesianProduct calls fn with every combination of values from all input sources, in the order of nested
+// loops - the last input source changes the fastest. Only the first input source is streamed, as all the
+// others have to be gone through again for each of its arguments. It never fails - the error is only there to make
+// it interchangeable with linkSources
+func cartesianProduct(sources []inputSource, fn func(values []inputArgument) (keepGoing bool)) error {
+	rest := make([][]inputArgument, len(sources)-1)
+	for i, source := range sources[1:] {
+		rest[i] = readAllArguments(source)
+		if len(rest[i]) == 0 {
+			return nil
+		}
+	}
+
+	for {
+		first, ok := sources[0].next()
+		if !ok {
+			return nil
+		}
+
+		indices := make([]int, len(rest))
+		for {
+			values := make([]inputArgument, 0, len(sources))
+			values = append(values, first)
+			for source, index := range indices {
+				values = append(values, rest[source][index])
+			}
+
+			if !fn(values) {
+				return nil
+			}
+
+			source := len(indices) - 1
+			for ; source >= 0; source-- {
+				indices[source] += 1
+				if indices[source] < len(rest[source]) {
+					break
+				}
+				indices[source] = 0
+			}
+
+			if source < 0 {
+				break
+			}
+		}
+	}
+}
+
+// linkSources calls fn with the first values of all input sources, then with the second ones, and so on. With
+// --link=wrap it goes on until the longest input source runs out, starting shorter ones over from the beginning.
+// Without it, input sources of different lengths are an error - one that only comes up for the ones streamed from
+// files, as lists after ":::" get checked up front by checkLinkedListLengths
+func linkSources(sources []inputSource, fn func(values []inputArgument) (keepGoing bool)) error {
+	wrap := *flLink == "wrap"
+
+	// only used with --link=wrap, to be able to start input sources over after they run out
+	seen := make([][]inputArgument, len(sources))
+	replayingFrom := make([]int, len(sources))
+	ranOut := make([]bool, len(sources))
+
+	nextFrom := func(source int) (argument inputArgument, ok bool) {
+		if ranOut[source] {
+			if replayingFrom[source] >= len(seen[source]) {
+				return inputArgument{}, false
+			}
+			replayingFrom[source] += 1
+			return seen[source][replayingFrom[source]-1], true
+		}
+
+		argument, ok = sources[source].next()
+		if ok && wrap {
+			seen[source] = append(seen[source], argument)
+		}
+		return argument, ok
+	}
+
+	for linked := 0; ; linked++ {
+		values := make([]inputArgument, len(sources))
+		ranOutNow := 0
+
+		for source := range sources {
+			argument, ok := nextFrom(source)
+			if !ok && wrap && len(seen[source]) > 0 {
+				ranOut[source] = true
+				replayingFrom[source] = 0
+				argument, ok = nextFrom(source)
+			}
+			if !ok {
+				ranOutNow += 1
+			}
+			values[source] = argument
+		}
+
+		if ranOutNow == len(sources) || (wrap && ranOutNow > 0) {
+			return nil
+		}
+		if ranOutNow > 0 {
+			// no more jobs get started, but the ones paired up correctly so far still run and show their output -
+			// including the last batch, which batchArguments only starts once this returns
+			return fmt.Errorf("cannot --link input sources of different lengths: some of them ran out after %d "+
+				"arguments and some didn't (use --link=wrap to start shorter ones over)", linked)
+		}
+		if wrap && !slices.Contains(ranOut, false) {
+			return nil
+		}
+
+		if !fn(values) {
+			return nil
+		}
+	}
+}
@@ -1,15 +1,12 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"os/exec"
 	"os/signal"
 	"runtime/debug"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -19,7 +16,6 @@ import (
 	"github.com/fatih/color"
 	"github.com/karolba/gparallel/chann"
 	"github.com/pkg/term/termios"
-	"golang.org/x/exp/slices"
 	"golang.org/x/term"
 )
 
@@ -35,6 +31,15 @@ var bold = color.New(color.Bold).SprintFunc()
 var yellow = color.New(color.FgYellow).SprintFunc()
 
 func writeOut(out *Output) {
+	clearOut(out, true)
+}
+
+// discardOut gets rid of all the output saved so far without writing it out, for --quiet-success
+func discardOut(out *Output) {
+	clearOut(out, false)
+}
+
+func clearOut(out *Output, write bool) {
 	var clearedOutBytes int64
 
 	offset := 0
@@ -44,15 +49,28 @@ func writeOut(out *Output) {
 			break
 		}
 
-		_, _ = standardFdToFile[fd].Write(content)
-
 		clearedOutBytes += chunkSizeWithHeader(content)
+		if !write {
+			continue
+		}
+
+		if fd&compressedChunk != 0 {
+			fd, content = fd&^compressedChunk, decompressChunk(content)
+		}
+
+		_, _ = standardFdToFile[fd].Write(content)
 	}
 
 	out.allocator.mustFree(out.parts)
 	out.allocator.mustClose()
 	out.parts = nil
 
+	if write {
+		out.writeOutSpilled()
+	} else {
+		out.discardSpilled()
+	}
+
 	// Just deallocated a lot due to a child process dying, let's also hint Go to do the same
 	debug.FreeOSMemory()
 
@@ -64,15 +82,45 @@ func writeOut(out *Output) {
 	mem.childDiedFreeingMemory.Broadcast()
 }
 
-func toForeground(proc *ProcessResult) (exitCode int) {
+func bringToForeground(proc *ProcessResult) {
 	proc.output.partsMutex.Lock()
 	writeOut(proc.output)
 	proc.output.shouldPassToParent = true
 	proc.output.partsMutex.Unlock()
+}
 
+func toForeground(proc *ProcessResult) (exitCode int) {
+	bringToForeground(proc)
 	return <-proc.exitCode // block until the process exits
 }
 
+// holdInForeground makes a job's output not count against --max-mem, like bringToForeground does, but keeps holding
+// on to it instead of passing it through - for --quiet-success, which needs the exit code to know what to do with it
+func holdInForeground(proc *ProcessResult) {
+	mem.childDiedFreeingMemory.L.Lock()
+	defer mem.childDiedFreeingMemory.L.Unlock()
+
+	mem.currentlyInTheForeground = proc.output
+	mem.childDiedFreeingMemory.Broadcast()
+}
+
+// reportFinished writes out the output of a job that's already finished - unless it's a successful one with
+// --quiet-success, which only gets its command printed with -v
+func reportFinished(proc *ProcessResult, exitCode int) {
+	if *flVerbose && !*flLineBuffer {
+		_, _ = fmt.Fprintf(os.Stderr, bold("+ %s")+"\n", shellescape.QuoteCommand(proc.originalCommand))
+	}
+
+	proc.output.partsMutex.Lock()
+	defer proc.output.partsMutex.Unlock()
+
+	if *flQuietSuccess && exitCode == 0 {
+		discardOut(proc.output)
+	} else {
+		writeOut(proc.output)
+	}
+}
+
 func tryToIncreaseNoFile() {
 	var rLimit syscall.Rlimit
 	err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rLimit)
@@ -93,7 +141,7 @@ func waitForChildrenAfterAFailedOne(processes <-chan *ProcessResult) {
 
 		wg.Add(1)
 		go func() {
-			<-processResult.exitCode
+			summary.jobStoppedAfterFailure(processResult, <-processResult.exitCode)
 			wg.Done()
 		}()
 	}
@@ -101,30 +149,6 @@ func waitForChildrenAfterAFailedOne(processes <-chan *ProcessResult) {
 	wg.Wait()
 }
 
-func instantiateCommandString(command []string, argument string) []string {
-	if *flTemplate == "" {
-		return append(command, argument)
-	}
-
-	replacedIn := 0
-
-	for i, word := range command {
-		if !strings.Contains(word, *flTemplate) {
-			continue
-		}
-
-		command[i] = strings.ReplaceAll(command[i], *flTemplate, argument)
-		replacedIn += 1
-	}
-
-	if replacedIn == 0 {
-		// If there's no {}-template anywhere, let's just append the argument at the end
-		return append(command, argument)
-	} else {
-		return command
-	}
-}
-
 func resetTermStateBeforeExit(originalTermState *term.State) {
 	if originalTermState != nil {
 		err := term.Restore(syscall.Stdout, originalTermState)
@@ -134,36 +158,51 @@ func resetTermStateBeforeExit(originalTermState *term.State) {
 	}
 }
 
-func startProcessesFromCliArguments(args Args, result chan<- *ProcessResult) {
-	for _, argument := range args.data {
-		if noLongerSpawnChildren.Load() {
-			break
-		}
-
-		result <- run(instantiateCommandString(slices.Clone(args.command), argument))
+// startProcessesFromInputSources runs the command for every set of arguments from the input sources. An error means
+// that they turned out to be unusable only after some jobs had already been started - those get to finish normally
+func startProcessesFromInputSources(args Args, result chan<- *ProcessResult) (err error) {
+	combine := cartesianProduct
+	if *flLink != "" {
+		combine = linkSources
 	}
-}
 
-func startProcessesFromStdin(args Args, result chan<- *ProcessResult) {
-	stdinReader := bufio.NewReader(os.Stdin)
-
-	for {
-		line, err := stdinReader.ReadString('\n')
-		line = strings.TrimSuffix(line, "\n")
+	forEachBatch := func(fn func(batch []jobArguments) (keepGoing bool)) {
+		batchArguments(args.command, func(fn func(arguments jobArguments) (keepGoing bool)) {
+			err = combine(args.inputSources, func(values []inputArgument) (keepGoing bool) {
+				return fn(newJobArguments(values))
+			})
+		}, fn)
+	}
 
-		if noLongerSpawnChildren.Load() {
-			break
-		}
-		if len(line) > 0 {
-			result <- run(instantiateCommandString(slices.Clone(args.command), line))
-		}
+	if *flTee {
+		startProcessesWithTee(args.command, os.Stdin, forEachBatch, result)
+		return err
+	}
 
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			log.Fatalf("Failed reading: %v\n", err)
+	total := 0
+	if usesPlaceholder(args.command, "##") {
+		// {##} needs to know how many jobs there are going to be before starting any of them
+		var everything [][]jobArguments
+		forEachBatch(func(batch []jobArguments) (keepGoing bool) {
+			everything = append(everything, batch)
+			return true
+		})
+
+		total = len(everything)
+		forEachBatch = func(fn func(batch []jobArguments) (keepGoing bool)) {
+			for _, batch := range everything {
+				if !fn(batch) {
+					return
+				}
+			}
 		}
 	}
+
+	sequence := 0
+	forEachBatch(func(batch []jobArguments) (keepGoing bool) {
+		return runBatch(args.command, batch, &sequence, total, result)
+	})
+	return err
 }
 
 func displaySequentially(processes <-chan *ProcessResult) (exitCode int) {
@@ -191,9 +230,13 @@ func displaySequentially(processes <-chan *ProcessResult) (exitCode int) {
 		}()
 	}
 
+	if *flUnordered {
+		return displayInCompletionOrder(processes)
+	}
+
 	firstProcess := true
 	for processResult := range processes {
-		if *flVerbose {
+		if *flVerbose && !*flLineBuffer && !*flQuietSuccess {
 			quotedCommand := shellescape.QuoteCommand(processResult.originalCommand)
 
 			if firstProcess || !stdoutIsTty() {
@@ -212,7 +255,17 @@ func displaySequentially(processes <-chan *ProcessResult) (exitCode int) {
 			}
 		}
 
-		exitCode = max(exitCode, toForeground(processResult))
+		if *flQuietSuccess {
+			holdInForeground(processResult)
+			processExitCode := <-processResult.exitCode
+			reportFinished(processResult, processExitCode)
+			summary.jobFinished(processResult, processExitCode)
+			exitCode = max(exitCode, processExitCode)
+		} else {
+			processExitCode := toForeground(processResult)
+			summary.jobFinished(processResult, processExitCode)
+			exitCode = max(exitCode, processExitCode)
+		}
 
 		if !*flKeepGoingOnError {
 			if exitCode != 0 {
@@ -296,6 +349,8 @@ func main() {
 	}
 
 	processes := chann.New[*ProcessResult]()
+	// only ever gets an error from the input sources, once all the jobs have been started
+	startingFailed := make(chan error, 1)
 	go func() {
 		defer processes.Close()
 
@@ -304,13 +359,31 @@ func main() {
 			return
 		}
 
-		if args.hasTripleColon {
-			startProcessesFromCliArguments(args, processes.In())
+		if *flPipe {
+			startProcessesFromPipe(os.Stdin, args.command, processes.In())
+			return
+		}
+
+		if *flPipepart {
+			startProcessesFromFileParts(args.partedFiles, args.command, processes.In())
+			return
 		}
-		if *flFromStdin {
-			startProcessesFromStdin(args, processes.In())
+
+		if err := startProcessesFromInputSources(args, processes.In()); err != nil {
+			startingFailed <- err
 		}
 	}()
 
-	os.Exit(displaySequentially(processes.Out()))
+	exitCode := displaySequentially(processes.Out())
+	if *flSummary >= 0 {
+		summary.print(*flSummary)
+	}
+	select {
+	case err := <-startingFailed:
+		// everything already started has been displayed by now, as processes only get closed after this is sent
+		log.Printf("%v\n", err)
+		exitCode = max(exitCode, 1)
+	default:
+	}
+	os.Exit(exitCode)
 }
@@ -1,8 +1,15 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/flate"
 	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
 	"log"
+	"os"
 	"sync"
 	"sync/atomic"
 	"unsafe"
@@ -15,10 +22,22 @@ var mem = struct {
 	childDiedFreeingMemory   *sync.Cond
 	currentlyInTheForeground *Output
 	currentlyStored          atomic.Int64
+	// gets a value when some output is blocked on the limit, for --unordered to bring a job to the foreground
+	someoneIsBlocked chan struct{}
 }{
 	sync.NewCond(&sync.Mutex{}),
 	nil,
 	atomic.Int64{},
+	make(chan struct{}, 1),
+}
+
+// wakeUpBlockedOutputs makes outputs blocked on the memory limit check it again - and let us know with
+// mem.someoneIsBlocked if they still can't go on
+func wakeUpBlockedOutputs() {
+	mem.childDiedFreeingMemory.L.Lock()
+	defer mem.childDiedFreeingMemory.L.Unlock()
+
+	mem.childDiedFreeingMemory.Broadcast()
 }
 
 type chunkAllocator struct{ memory.Allocator }
@@ -51,7 +70,60 @@ func (allocator *chunkAllocator) mustClose() {
 	}
 }
 
+// compressedChunk marks chunks compressed with --compress, in the same byte as the fd they're from
+const compressedChunk = 0x80
+
+var compressors = sync.Pool{New: func() any {
+	writer, err := flate.NewWriter(nil, flate.BestSpeed)
+	if err != nil {
+		log.Fatalf("Could not create a compressor: %v\n", err)
+	}
+	return writer
+}}
+
+var decompressors = sync.Pool{New: func() any {
+	return flate.NewReader(nil)
+}}
+
+func compressChunk(data []byte) []byte {
+	var compressed bytes.Buffer
+
+	writer := compressors.Get().(*flate.Writer)
+	defer compressors.Put(writer)
+
+	writer.Reset(&compressed)
+	_, _ = writer.Write(data)
+	if err := writer.Close(); err != nil {
+		log.Fatalf("Could not compress output: %v\n", err)
+	}
+
+	return compressed.Bytes()
+}
+
+func decompressChunk(data []byte) []byte {
+	reader := decompressors.Get().(io.ReadCloser)
+	defer decompressors.Put(reader)
+
+	_ = reader.(flate.Resetter).Reset(bytes.NewReader(data), nil)
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		log.Fatalf("Could not decompress output: %v\n", err)
+	}
+
+	return decompressed
+}
+
 func (out *Output) appendChunk(dataFromFd byte, data []byte) {
+	if *flCompress {
+		if compressed := compressChunk(data); len(compressed) < len(data) {
+			// the memory limit was checked against the uncompressed size, so give back the difference
+			mem.currentlyStored.Add(int64(len(compressed) - len(data)))
+			wakeUpBlockedOutputs()
+
+			dataFromFd, data = dataFromFd|compressedChunk, compressed
+		}
+	}
+
 	chunk := out.newChunk(len(data) + 1) // +1 for dataFromFd
 
 	chunk[0] = dataFromFd
@@ -109,3 +181,86 @@ func chunkSizeWithHeader(data []byte) (size int64) {
 	size += int64(len(data))
 	return size
 }
+
+// spillChunk appends a chunk to a temporary file instead of memory, in the same format, for --spill
+func (out *Output) spillChunk(dataFromFd byte, data []byte) {
+	if out.spilled == nil {
+		out.spilled = createSpillFile()
+	}
+
+	chunk := make([]byte, int(chunkHeaderSize)+1, int(chunkHeaderSize)+1+len(data))
+	binary.LittleEndian.PutUint32(chunk, uint32(len(data)+1))
+	chunk[chunkHeaderSize] = dataFromFd
+	chunk = append(chunk, data...)
+
+	if _, err := out.spilled.Write(chunk); err != nil {
+		log.Fatalf("Could not spill output to %s: %v\n", out.spilled.Name(), err)
+	}
+}
+
+func createSpillFile() *os.File {
+	dir := *flTmpdir
+	if dir == "" {
+		// not dataDir(), which prefers /dev/shm - spilling into memory would defeat the point
+		dir = os.TempDir()
+	}
+
+	if err := os.MkdirAll(dir, fs.ModePerm); err != nil {
+		log.Fatalf("Cannot create directory %s for spilling output: %v\n", dir, err)
+	}
+
+	file, err := os.CreateTemp(dir, "spill-*")
+	if err != nil {
+		log.Fatalf("Could not create a file to spill output to: %v\n", err)
+	}
+
+	// nobody else needs to see it, and this way it goes away even if we get killed
+	_ = os.Remove(file.Name())
+
+	return file
+}
+
+func (out *Output) discardSpilled() {
+	if out.spilled != nil {
+		haveToClose("spilled output", out.spilled)
+		out.spilled = nil
+	}
+}
+
+// writeOutSpilled writes out everything spilled to disk, and gets rid of the file
+func (out *Output) writeOutSpilled() {
+	if out.spilled == nil {
+		return
+	}
+
+	if _, err := out.spilled.Seek(0, io.SeekStart); err != nil {
+		log.Fatalf("Could not read spilled output back from %s: %v\n", out.spilled.Name(), err)
+	}
+
+	reader := bufio.NewReaderSize(out.spilled, MAXBUF)
+	header := make([]byte, chunkHeaderSize)
+	var chunk []byte
+
+	for {
+		_, err := io.ReadFull(reader, header)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err == nil {
+			size := int(binary.LittleEndian.Uint32(header))
+			if cap(chunk) < size {
+				chunk = make([]byte, size)
+			}
+			chunk = chunk[:size]
+			_, err = io.ReadFull(reader, chunk)
+		}
+		if err != nil {
+			log.Fatalf("Could not read spilled output back from %s: %v\n", out.spilled.Name(), err)
+		}
+
+		_, _ = standardFdToFile[chunk[0]].Write(chunk[1:])
+	}
+
+	haveToClose("spilled output", out.spilled)
+	out.spilled = nil
+}
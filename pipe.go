@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+)
+
+// blockReader chops its input into blocks of about --block bytes each, always cut on a record boundary - so that
+// no job gets half of a record
+type blockReader struct {
+	reader    io.Reader
+	blockSize int
+	// a record boundary is between the end of --recend and the start of --recstart
+	separator      []byte
+	recordEndBytes int
+
+	pending []byte
+	eof     bool
+}
+
+func newBlockReader(reader io.Reader, blockSize int, recend string, recstart string) *blockReader {
+	return &blockReader{
+		reader:         reader,
+		blockSize:      blockSize,
+		separator:      []byte(recend + recstart),
+		recordEndBytes: len(recend),
+	}
+}
+
+func (b *blockReader) readUpTo(size int) {
+	buffer := bytes.NewBuffer(b.pending)
+	_, err := io.CopyN(buffer, b.reader, int64(size-len(b.pending)))
+	if errors.Is(err, io.EOF) {
+		b.eof = true
+	} else if err != nil {
+		log.Fatalf("Could not read input for --pipe: %v\n", err)
+	}
+	b.pending = buffer.Bytes()
+}
+
+// lastRecordBoundary returns where the last full record in what's been read so far ends, or 0 if there's no full
+// record yet
+func (b *blockReader) lastRecordBoundary() int {
+	index := bytes.LastIndex(b.pending, b.separator)
+	if index == -1 {
+		return 0
+	}
+	return index + b.recordEndBytes
+}
+
+func (b *blockReader) next() (block []byte, ok bool) {
+	want := b.blockSize
+
+	for {
+		if len(b.pending) < want && !b.eof {
+			b.readUpTo(want)
+		}
+
+		if b.eof {
+			block, b.pending = b.pending, nil
+			return block, len(block) > 0
+		}
+
+		if boundary := b.lastRecordBoundary(); boundary > 0 {
+			// copy the rest, the block itself is going to be held on to until the job reads it
+			block, b.pending = b.pending[:boundary], append([]byte(nil), b.pending[boundary:]...)
+			return block, true
+		}
+
+		// not even a single record fits in a block, so make it larger
+		want = len(b.pending) * 2
+	}
+}
+
+// startProcessesFromPipe runs the command once for every block of the input, passing the block to its stdin
+func startProcessesFromPipe(input io.Reader, command []string, result chan<- *ProcessResult) {
+	blocks := newBlockReader(input, parsedFlBlockSize, parsedFlRecend, parsedFlRecstart)
+	forEachBlock := func(fn func(block []byte) (keepGoing bool)) {
+		for block, ok := blocks.next(); ok; block, ok = blocks.next() {
+			if !fn(block) {
+				return
+			}
+		}
+	}
+
+	total := 0
+	if usesPlaceholder(command, "##") {
+		// {##} needs the whole input to be read up front to know how many blocks there are
+		var everything [][]byte
+		forEachBlock(func(block []byte) (keepGoing bool) {
+			everything = append(everything, block)
+			return true
+		})
+
+		total = len(everything)
+		forEachBlock = func(fn func(block []byte) (keepGoing bool)) {
+			for _, block := range everything {
+				if !fn(block) {
+					return
+				}
+			}
+		}
+	}
+
+	sequence := 0
+	forEachBlock(func(block []byte) (keepGoing bool) {
+		if noLongerSpawnChildren.Load() {
+			return summary.jobsNotStarted(1)
+		}
+
+		sequence += 1
+		process, err := runInSlot(func(slot int) job {
+			return newJob(command, []jobArguments{{sequence: sequence, slot: slot, total: total}})
+		}, bytes.NewReader(block))
+		if err != nil {
+			log.Fatalf("Could not start %v\n", err)
+		}
+
+		result <- process
+		return true
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"os"
+)
+
+// filePart is a record-aligned byte range of a file, passed to a single job with --pipepart
+type filePart struct {
+	file       *os.File
+	start, end int64
+	size       int64
+}
+
+// nextRecordBoundary finds the first record boundary at or after a given offset of a file, or returns the file's
+// size if there isn't one
+func nextRecordBoundary(file *os.File, from int64, size int64) int64 {
+	separator := []byte(parsedFlRecend + parsedFlRecstart)
+	buffer := make([]byte, max(64*1024, 2*len(separator)))
+
+	offset := from - int64(len(parsedFlRecend))
+	if offset < 0 {
+		offset = 0
+	}
+
+	for {
+		n, err := file.ReadAt(buffer, offset)
+		if index := bytes.Index(buffer[:n], separator); index != -1 {
+			return offset + int64(index+len(parsedFlRecend))
+		}
+		if errors.Is(err, io.EOF) {
+			return size
+		}
+		if err != nil {
+			log.Fatalf("Could not read %s for --pipepart: %v\n", file.Name(), err)
+		}
+
+		// keep an overlap, in case the separator is split between two reads
+		offset += int64(n - len(separator) + 1)
+	}
+}
+
+// splitFile computes the parts of a file for --pipepart, with only a few small reads around every cut
+func splitFile(fileName string) (parts []filePart) {
+	if fileName == "-" {
+		log.Fatalf("--pipepart cannot seek in stdin - use --pipe for it instead\n")
+	}
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		log.Fatalf("Could not open %s for --pipepart: %v\n", fileName, err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		log.Fatalf("Could not stat %s for --pipepart: %v\n", fileName, err)
+	}
+	if !stat.Mode().IsRegular() {
+		log.Fatalf("%s is not a regular file, --pipepart needs to be able to seek in it - use --pipe instead\n", fileName)
+	}
+
+	size := stat.Size()
+	for start := int64(0); start < size; {
+		end := size
+		if start+int64(parsedFlBlockSize) < size {
+			end = nextRecordBoundary(file, start+int64(parsedFlBlockSize), size)
+		}
+
+		parts = append(parts, filePart{file: file, start: start, end: end, size: size})
+		start = end
+	}
+
+	return parts
+}
+
+// open gives the part its own file descriptor for a job to read it from. The last part of a file is just the file
+// itself opened again and seeked - others are a pipe the part gets copied into, without passing through our memory
+// where the OS allows it
+func (part filePart) open() (stdin *os.File, feed func()) {
+	if part.end == part.size {
+		file, err := os.Open(part.file.Name())
+		if err != nil {
+			log.Fatalf("Could not open %s for --pipepart: %v\n", part.file.Name(), err)
+		}
+		if _, err := file.Seek(part.start, io.SeekStart); err != nil {
+			log.Fatalf("Could not seek in %s for --pipepart: %v\n", part.file.Name(), err)
+		}
+		return file, func() {}
+	}
+
+	pipeReader, pipeWriter, err := os.Pipe()
+	if err != nil {
+		log.Fatalf("Could not create a pipe for --pipepart: %v\n", err)
+	}
+
+	return pipeReader, func() {
+		go func() {
+			// an error here just means the job exited without reading everything, which is fine
+			_ = copyFileRange(pipeWriter, part.file, part.start, part.end-part.start)
+			_ = pipeWriter.Close()
+		}()
+	}
+}
+
+// startProcessesFromFileParts runs the command once for every part of every file, with the part as its stdin
+func startProcessesFromFileParts(fileNames []string, command []string, result chan<- *ProcessResult) {
+	var parts []filePart
+	for _, fileName := range fileNames {
+		parts = append(parts, splitFile(fileName)...)
+	}
+
+	for i, part := range parts {
+		if noLongerSpawnChildren.Load() {
+			summary.jobsNotStarted(len(parts) - i)
+			return
+		}
+
+		stdin, feed := part.open()
+		process, err := runInSlot(func(slot int) job {
+			return newJob(command, []jobArguments{{sequence: i + 1, slot: slot, total: len(parts)}})
+		}, stdin)
+		if err != nil {
+			log.Fatalf("Could not start %v\n", err)
+		}
+
+		// the job has its own copy of the descriptor now
+		_ = stdin.Close()
+		feed()
+
+		result <- process
+	}
+}
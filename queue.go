@@ -195,7 +195,10 @@ func startProcessesFromQueue(result chan<- *ProcessResult) {
 			}
 
 			if noLongerSpawnChildren.Load() {
-				break
+				if !summary.jobsNotStarted(1) {
+					break
+				}
+				continue
 			}
 
 			if qc.WithStdin {
@@ -84,7 +84,7 @@ func createLimitServer() {
 }
 
 var recursiveTaskLimitClient = onceValue(func() (client struct {
-	// waits before we're allowed to start a new process
+	// waits before we're allowed to start a new process, and assigns it a slot
 	addWait func(result *ProcessResult)
 
 	// called when a process dies, to make room for a new one
@@ -121,6 +121,18 @@ var recursiveTaskLimitClient = onceValue(func() (client struct {
 		processQueueData := &ProcessQueueData{processResult: result}
 		queue = append(queue, processQueueData)
 
+		// once we're allowed to start, take the lowest slot number not taken by any other running process. As
+		// only up to -P processes can run at once, slots always stay in 1..-P
+		defer func() {
+			taken := make([]bool, len(queue)+1)
+			for _, processQueueData := range queue {
+				if processQueueData.processResult != result && processQueueData.processResult.slot < len(taken) {
+					taken[processQueueData.processResult.slot] = true
+				}
+			}
+			result.slot = slices.Index(taken[1:], false) + 1
+		}()
+
 		if len(queue) == 1 {
 			return
 		}
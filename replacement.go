@@ -0,0 +1,513 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alessio/shellescape"
+	"golang.org/x/exp/slices"
+)
+
+// jobArguments are what placeholders in a job's command get replaced with
+type jobArguments struct {
+	// whole arguments, one from each input source - together they make up {}
+	whole []string
+	// every input source's argument split into columns, one input source after another. These are {1}, {2},
+	// and so on - and without --colsep or --csv they are the same as whole
+	columns []string
+	// columns named by --header, and named groups from --match
+	named map[string]string
+
+	// {#}, {%} and {##} - the number of the job, its slot from 1 to -P, and the number of all jobs
+	sequence, slot, total int
+}
+
+func newJobArguments(values []inputArgument) (arguments jobArguments) {
+	for _, value := range values {
+		arguments.whole = append(arguments.whole, value.whole)
+		arguments.columns = append(arguments.columns, value.columns...)
+
+		if arguments.named == nil && (len(value.columnNames) > 0 || len(value.matchedGroups) > 0) {
+			arguments.named = make(map[string]string)
+		}
+		for i, name := range value.columnNames {
+			if i < len(value.columns) {
+				arguments.named[name] = value.columns[i]
+			} else {
+				arguments.named[name] = ""
+			}
+		}
+		for name, group := range value.matchedGroups {
+			arguments.named[name] = group
+		}
+	}
+	return arguments
+}
+
+// placeholderDelimiters splits the -I replacement string in half, to get the delimiters of all the other
+// placeholders - so "{}" gives us "{1}", "{2}", and so on, and "[[]]" gives us "[[1]]". Replacement strings
+// of odd length can't be split, and only ever get replaced literally
+func placeholderDelimiters() (open, close string, ok bool) {
+	if len(*flTemplate) < 2 || len(*flTemplate)%2 != 0 {
+		return "", "", false
+	}
+
+	half := len(*flTemplate) / 2
+	return (*flTemplate)[:half], (*flTemplate)[half:], true
+}
+
+// findPlaceholderEnd returns the index of the close delimiter matching an open delimiter that ends right
+// before word[from], or -1 if there isn't one
+func findPlaceholderEnd(word string, from int, open, close string) int {
+	depth := 1
+
+	for i := from; i < len(word); {
+		switch {
+		case strings.HasPrefix(word[i:], close):
+			depth -= 1
+			if depth == 0 {
+				return i
+			}
+			i += len(close)
+		case open != close && strings.HasPrefix(word[i:], open):
+			depth += 1
+			i += len(open)
+		default:
+			i += 1
+		}
+	}
+
+	return -1
+}
+
+// replacePlaceholders calls replace with the contents of every placeholder in word, e.g. "1" for "{1}", and
+// substitutes it with what replace returns. Placeholders that replace doesn't recognise are left as they are,
+// so that things like awk '{print $1}' still work
+func replacePlaceholders(word string, replace func(content string) (value string, ok bool)) (result string, replacedAny bool) {
+	if *flTemplate == "" {
+		// -I '' turns placeholders off altogether
+		return word, false
+	}
+
+	open, close, ok := placeholderDelimiters()
+	if !ok {
+		if !strings.Contains(word, *flTemplate) {
+			return word, false
+		}
+		value, _ := replace("")
+		return strings.ReplaceAll(word, *flTemplate, value), true
+	}
+
+	var builder strings.Builder
+
+	for {
+		start := strings.Index(word, open)
+		if start == -1 {
+			break
+		}
+
+		contentStart := start + len(open)
+		end := findPlaceholderEnd(word, contentStart, open, close)
+		if end == -1 {
+			// this open delimiter is never closed - but one after it might still be
+			builder.WriteString(word[:contentStart])
+			word = word[contentStart:]
+			continue
+		}
+
+		value, ok := replace(word[contentStart:end])
+		if ok {
+			builder.WriteString(word[:start])
+			builder.WriteString(value)
+			word = word[end+len(close):]
+			replacedAny = true
+		} else {
+			// not a placeholder we know - but there might still be one nested inside it
+			builder.WriteString(word[:contentStart])
+			word = word[contentStart:]
+		}
+	}
+	builder.WriteString(word)
+
+	return builder.String(), replacedAny
+}
+
+// pathModifiers transform an argument as a path. They can follow any other placeholder, like {1/} or {name.},
+// or stand on their own and apply to the whole argument. Two-character ones come first to be matched first
+var pathModifiers = []struct {
+	suffix    string
+	transform func(path string) string
+}{
+	{"//", filepath.Dir},
+	{"/.", func(path string) string { return removeExtension(filepath.Base(path)) }},
+	{"/", filepath.Base},
+	{".", removeExtension},
+}
+
+// removeExtension removes the extension from a path - but only from its last element, and not if that's a
+// hidden file like .bashrc
+func removeExtension(path string) string {
+	extension := filepath.Ext(path)
+	if extension == "" || extension == filepath.Base(path) {
+		return path
+	}
+	return strings.TrimSuffix(path, extension)
+}
+
+// argumentPlaceholder resolves the contents of a placeholder: a plainPlaceholder, optionally followed by
+// placeholderFunctions to pass its value through, like {1|upper|q}. A lone function applies to the whole
+// argument, so {upper} means the same thing as {|upper}
+func argumentPlaceholder(content string, arguments jobArguments) (value string, ok bool) {
+	expressions := splitUnescaped(content, '|', -1)
+
+	value, ok = plainPlaceholder(expressions[0], arguments)
+	functions := expressions[1:]
+	if !ok {
+		value, _ = plainPlaceholder("", arguments)
+		functions = expressions
+	}
+
+	for _, function := range functions {
+		value, ok = applyPlaceholderFunction(function, value)
+		if !ok {
+			return "", false
+		}
+	}
+
+	return value, true
+}
+
+// plainPlaceholder resolves a placeholder without any functions: an empty one means all arguments, {1}, {2}, etc.
+// mean the first, second, etc. column - which, without --colsep, is the argument from that input source - and
+// {name} means a column named name by --header, or a named group from --match. Any of them can also end with one
+// of the pathModifiers. On top of that, there are {#}, {%} and {##}
+func plainPlaceholder(content string, arguments jobArguments) (value string, ok bool) {
+	switch content {
+	case "#":
+		return strconv.Itoa(arguments.sequence), true
+	case "%":
+		return strconv.Itoa(arguments.slot), true
+	case "##":
+		return strconv.Itoa(arguments.total), true
+	}
+
+	if value, ok = selectArgument(content, arguments); ok {
+		return value, true
+	}
+
+	for _, modifier := range pathModifiers {
+		selector := strings.TrimSuffix(content, modifier.suffix)
+		if selector == content {
+			continue
+		}
+
+		if selector == "" {
+			// apply the modifier to every whole argument on its own, not to all of them joined together
+			transformed := make([]string, len(arguments.whole))
+			for i, argument := range arguments.whole {
+				transformed[i] = modifier.transform(argument)
+			}
+			return strings.Join(transformed, " "), true
+		}
+
+		if value, ok = selectArgument(selector, arguments); ok {
+			return modifier.transform(value), true
+		}
+	}
+
+	return "", false
+}
+
+func selectArgument(selector string, arguments jobArguments) (value string, ok bool) {
+	if selector == "" {
+		return strings.Join(arguments.whole, " "), true
+	}
+
+	position, err := strconv.Atoi(selector)
+	if err != nil || position < 1 || strings.HasPrefix(selector, "+") {
+		value, ok = arguments.named[selector]
+		return value, ok
+	}
+	if position > len(arguments.columns) {
+		// most likely not meant as a placeholder at all, like the repetition in grep -E '[0-9]{3}'
+		return "", false
+	}
+	return arguments.columns[position-1], true
+}
+
+// placeholderFunctions can transform placeholder values, with arguments separated by colons - {replace:a:b}. The
+// last argument takes up the rest of the placeholder, so colons in it don't need escaping. Other colons, and all
+// pipe characters, can be escaped with a backslash: {replace:\::-} or {regex:(?:a\|b)}
+var placeholderFunctions = map[string]struct {
+	arguments int
+	apply     func(value string, arguments []string) string
+}{
+	"upper": {0, func(value string, _ []string) string { return strings.ToUpper(value) }},
+	"lower": {0, func(value string, _ []string) string { return strings.ToLower(value) }},
+	// a value quoted to be safe to use in a shell command
+	"q": {0, func(value string, _ []string) string { return shellescape.Quote(value) }},
+	// a JSON string, quotes included
+	"json": {0, func(value string, _ []string) string {
+		encoded, _ := json.Marshal(value)
+		return string(encoded)
+	}},
+	"replace": {2, func(value string, arguments []string) string {
+		return strings.ReplaceAll(value, arguments[0], arguments[1])
+	}},
+	// the first capture group of the first match - or the whole match if there aren't any groups, or nothing if
+	// the regex doesn't match at all
+	"regex": {1, func(value string, arguments []string) string {
+		match := compiledPlaceholderRegex(arguments[0]).FindStringSubmatch(value)
+		switch {
+		case match == nil:
+			return ""
+		case len(match) > 1:
+			return match[1]
+		default:
+			return match[0]
+		}
+	}},
+}
+
+var placeholderRegexes = map[string]*regexp.Regexp{}
+
+func compiledPlaceholderRegex(pattern string) *regexp.Regexp {
+	if compiled, ok := placeholderRegexes[pattern]; ok {
+		return compiled
+	}
+
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		log.Fatalf("Invalid regular expression '%s' in a placeholder: %v\n", pattern, err)
+	}
+
+	placeholderRegexes[pattern] = compiled
+	return compiled
+}
+
+func applyPlaceholderFunction(expression string, value string) (result string, ok bool) {
+	nameAndArguments := splitUnescaped(expression, ':', 2)
+
+	function, ok := placeholderFunctions[nameAndArguments[0]]
+	if !ok {
+		return "", false
+	}
+
+	var arguments []string
+	if function.arguments > 0 && len(nameAndArguments) == 2 {
+		arguments = splitUnescaped(nameAndArguments[1], ':', function.arguments)
+	}
+	if len(arguments) != function.arguments || (function.arguments == 0 && len(nameAndArguments) != 1) {
+		return "", false
+	}
+
+	for i := range arguments {
+		arguments[i] = placeholderEscapes.Replace(arguments[i])
+	}
+
+	return function.apply(value, arguments), true
+}
+
+var placeholderEscapes = strings.NewReplacer("\\:", ":", "\\|", "|")
+
+// splitUnescaped works like strings.SplitN, but doesn't split on separators escaped with a backslash - leaving
+// the escapes in
+func splitUnescaped(value string, separator byte, n int) (parts []string) {
+	start := 0
+	for i := 0; i < len(value) && (n < 0 || len(parts) < n-1); i++ {
+		switch value[i] {
+		case '\\':
+			i += 1
+		case separator:
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, value[start:])
+}
+
+// usesPlaceholder checks if there's a given placeholder anywhere in the command, e.g. "##" for {##}
+func usesPlaceholder(command []string, content string) (found bool) {
+	for _, word := range command {
+		_, _ = replacePlaceholders(word, func(placeholder string) (string, bool) {
+			found = found || placeholder == content
+			return "", false
+		})
+	}
+	return found
+}
+
+// quoteForShell makes a value safe to put in a shell command with --shell, and leaves it alone otherwise
+func quoteForShell(value string) string {
+	if parsedFlShell == "" {
+		return value
+	}
+	return shellescape.Quote(value)
+}
+
+// quotePlaceholderForShell is quoteForShell for the value of a placeholder - unless the placeholder already quotes
+// it itself, ending with the {q} function
+func quotePlaceholderForShell(content string, value string, arguments jobArguments) string {
+	expressions := splitUnescaped(content, '|', -1)
+	if _, plain := plainPlaceholder(content, arguments); !plain && expressions[len(expressions)-1] == "q" {
+		return value
+	}
+	return quoteForShell(value)
+}
+
+// replaceArgumentPlaceholders replaces all placeholders in a word with a single set of arguments
+func replaceArgumentPlaceholders(word string, arguments jobArguments) (result string, replacedAny bool) {
+	return replacePlaceholders(word, func(content string) (string, bool) {
+		value, ok := argumentPlaceholder(content, arguments)
+		return quotePlaceholderForShell(content, value, arguments), ok
+	})
+}
+
+// isJobPlaceholder tells whether a placeholder is the same for all the arguments batched into a single job
+func isJobPlaceholder(content string) bool {
+	switch splitUnescaped(content, '|', 2)[0] {
+	case "#", "%", "##":
+		return true
+	default:
+		return false
+	}
+}
+
+// classifyWord tells whether there's nothing but placeholders in a word, like "{}" or "{1}{2}", and whether any of
+// them depend on the arguments - as opposed to {#} or {%}, which only depend on the job
+func classifyWord(word string, arguments jobArguments) (onlyPlaceholders bool, dependsOnArguments bool) {
+	withoutPlaceholders, _ := replacePlaceholders(word, func(content string) (string, bool) {
+		_, ok := argumentPlaceholder(content, arguments)
+		dependsOnArguments = dependsOnArguments || (ok && !isJobPlaceholder(content))
+		return "", ok
+	})
+	return withoutPlaceholders == "", dependsOnArguments
+}
+
+func appendColumns(command []string, batch []jobArguments) []string {
+	for _, arguments := range batch {
+		for _, column := range arguments.columns {
+			command = append(command, quoteForShell(column))
+		}
+	}
+	return command
+}
+
+// instantiateCommandString fills in a command for a batch of arguments - usually of just one set of them, but
+// more with -n, -m or -X. Then, words that are just a placeholder are repeated for every set, and other words
+// with placeholders have them replaced by values from every set joined with spaces - unless it's -X, with which
+// all words with placeholders are repeated
+func instantiateCommandString(command []string, batch []jobArguments) (instantiated []string) {
+	if *flTemplate == "" {
+		return appendColumns(slices.Clone(command), batch)
+	}
+
+	replacedIn := 0
+
+	for _, word := range command {
+		replaced := make([]string, len(batch))
+		replacedAny := false
+		for i, arguments := range batch {
+			replaced[i], replacedAny = replaceArgumentPlaceholders(word, arguments)
+		}
+
+		if !replacedAny {
+			instantiated = append(instantiated, word)
+			continue
+		}
+		replacedIn += 1
+
+		onlyPlaceholders, dependsOnArguments := classifyWord(word, batch[0])
+		if !dependsOnArguments {
+			instantiated = append(instantiated, replaced[0])
+			continue
+		}
+		if len(batch) == 1 || *flContextReplace || onlyPlaceholders {
+			instantiated = append(instantiated, replaced...)
+			continue
+		}
+
+		joined, _ := replacePlaceholders(word, func(content string) (string, bool) {
+			if isJobPlaceholder(content) {
+				value, ok := argumentPlaceholder(content, batch[0])
+				return quotePlaceholderForShell(content, value, batch[0]), ok
+			}
+
+			values := make([]string, len(batch))
+			for i, arguments := range batch {
+				value, ok := argumentPlaceholder(content, arguments)
+				if !ok {
+					return "", false
+				}
+				values[i] = quotePlaceholderForShell(content, value, arguments)
+			}
+			return strings.Join(values, " "), true
+		})
+		instantiated = append(instantiated, joined)
+	}
+
+	if replacedIn == 0 {
+		// If there's no {}-template anywhere, let's just append the arguments at the end
+		return appendColumns(instantiated, batch)
+	} else {
+		return instantiated
+	}
+}
+
+// fillTemplate fills in the placeholders of a template that isn't a command, like --tag-string or --output - so
+// without any quoting, and with the values of all the arguments in a batch joined with spaces
+func fillTemplate(template string, batch []jobArguments) string {
+	filled, _ := replacePlaceholders(template, func(content string) (string, bool) {
+		if isJobPlaceholder(content) {
+			return argumentPlaceholder(content, batch[0])
+		}
+
+		values := make([]string, len(batch))
+		for i, arguments := range batch {
+			value, ok := argumentPlaceholder(content, arguments)
+			if !ok {
+				return "", false
+			}
+			values[i] = value
+		}
+		return strings.Join(values, " "), true
+	})
+	return filled
+}
+
+// newJob instantiates everything about a job for a batch of arguments
+func newJob(command []string, batch []jobArguments) job {
+	job := job{
+		command: instantiateJob(command, batch),
+		tag:     tagForJob(batch),
+	}
+	if *flOutput != "" {
+		job.stdoutPath = fillTemplate(*flOutput, batch)
+	}
+	if *flOutputStderr != "" {
+		job.stderrPath = fillTemplate(*flOutputStderr, batch)
+	}
+	return job
+}
+
+// instantiateJob fills in a command with instantiateCommandString, and with --shell turns it into a shell script. An
+// empty command means that the arguments are commands themselves
+func instantiateJob(command []string, batch []jobArguments) []string {
+	if parsedFlShell == "" {
+		return instantiateCommandString(command, batch)
+	}
+
+	if len(command) == 0 {
+		scripts := make([]string, len(batch))
+		for i, arguments := range batch {
+			scripts[i] = strings.Join(arguments.whole, " ")
+		}
+		return []string{parsedFlShell, "-c", strings.Join(scripts, "\n")}
+	}
+
+	return []string{parsedFlShell, "-c", strings.Join(instantiateCommandString(command, batch), " ")}
+}
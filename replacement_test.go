@@ -0,0 +1,232 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func withTemplate(t *testing.T, template string) {
+	previous := *flTemplate
+	*flTemplate = template
+	t.Cleanup(func() { *flTemplate = previous })
+}
+
+func withShell(t *testing.T, shell string) {
+	previous := parsedFlShell
+	parsedFlShell = shell
+	t.Cleanup(func() { parsedFlShell = previous })
+}
+
+var testArguments = jobArguments{
+	whole:    []string{"dir/a b.txt", "x"},
+	columns:  []string{"dir/a b.txt", "x"},
+	named:    map[string]string{"name": "Value"},
+	sequence: 3,
+	slot:     2,
+	total:    7,
+}
+
+func TestReplaceArgumentPlaceholders(t *testing.T) {
+	tests := []struct {
+		word         string
+		want         string
+		wantReplaced bool
+	}{
+		{"{}", "dir/a b.txt x", true},
+		{"{1}-{2}", "dir/a b.txt-x", true},
+		{"{name}", "Value", true},
+		{"{#}/{%}/{##}", "3/2/7", true},
+
+		// paths
+		{"{1.}", "dir/a b", true},
+		{"{1/}", "a b.txt", true},
+		{"{1//}", "dir", true},
+		{"{1/.}", "a b", true},
+		{"{/.}", "a b x", true},
+
+		// functions
+		{"{2|upper}", "X", true},
+		{"{name|lower}", "value", true},
+		{"{upper}", "DIR/A B.TXT X", true},
+		{"{1|replace: :_}", "dir/a_b.txt", true},
+		{"{1|replace:\\::-}", "dir/a b.txt", true},
+		{"{1|regex:/(.*)\\.}", "a b", true},
+		{"{1|regex:nothing}", "", true},
+		{"{1|json}", `"dir/a b.txt"`, true},
+		{"{1|q}", "'dir/a b.txt'", true},
+		{"{1/|upper}", "A B.TXT", true},
+		{"{1|/}", "{1|/}", false},
+
+		// left alone
+		{"plain", "plain", false},
+		{"awk '{print $1}'", "awk '{print $1}'", false},
+		{"[0-9]{3}", "[0-9]{3}", false},
+		{"{nope}", "{nope}", false},
+		{"{2|nope}", "{2|nope}", false},
+		{"{1|replace:onlyone}", "{1|replace:onlyone}", false},
+		{"{1|upper:extra}", "{1|upper:extra}", false},
+
+		// delimiters that don't pair up
+		{"x{{}", "x{dir/a b.txt x", true},
+		{"{", "{", false},
+		{"}{", "}{", false},
+		{"{a{}}", "{adir/a b.txt x}", true},
+		{"{{2}}", "{x}", true},
+	}
+
+	for _, test := range tests {
+		got, replaced := replaceArgumentPlaceholders(test.word, testArguments)
+		if got != test.want || replaced != test.wantReplaced {
+			t.Errorf("replaceArgumentPlaceholders(%q) = %q, %v; want %q, %v", test.word, got, replaced, test.want, test.wantReplaced)
+		}
+	}
+}
+
+func TestReplacePlaceholdersWithOtherTemplates(t *testing.T) {
+	tests := []struct {
+		template     string
+		word         string
+		want         string
+		wantReplaced bool
+	}{
+		{"<>", "<1>,<2|upper>", "dir/a b.txt,X", true},
+		{"<>", "{1}", "{1}", false},
+		{"[[]]", "a[[2]]b", "axb", true},
+		{"[[]]", "[[nope]]", "[[nope]]", false},
+		// without halves to tell apart, it's a placeholder of its own
+		{"@", "a@b", "adir/a b.txt xb", true},
+		{"@", "{}", "{}", false},
+		// no placeholders at all
+		{"", "a{}b", "a{}b", false},
+		{"", "ab", "ab", false},
+	}
+
+	for _, test := range tests {
+		withTemplate(t, test.template)
+		got, replaced := replaceArgumentPlaceholders(test.word, testArguments)
+		if got != test.want || replaced != test.wantReplaced {
+			t.Errorf("-I %q: replaceArgumentPlaceholders(%q) = %q, %v; want %q, %v",
+				test.template, test.word, got, replaced, test.want, test.wantReplaced)
+		}
+	}
+}
+
+func TestReplaceArgumentPlaceholdersWithShell(t *testing.T) {
+	withShell(t, "/bin/sh")
+
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"{1}", "'dir/a b.txt'"},
+		{"{2}", "x"},
+		{"{1|upper}", "'DIR/A B.TXT'"},
+		// already quoted, so not quoted again
+		{"{1|q}", "'dir/a b.txt'"},
+		{"{1|q|upper}", `''"'"'DIR/A B.TXT'"'"''`},
+		{"{#}", "3"},
+	}
+
+	for _, test := range tests {
+		got, _ := replaceArgumentPlaceholders(test.word, testArguments)
+		if got != test.want {
+			t.Errorf("with --shell, replaceArgumentPlaceholders(%q) = %q; want %q", test.word, got, test.want)
+		}
+	}
+}
+
+func TestReplaceArgumentPlaceholdersWithShellAndColumnNamedQ(t *testing.T) {
+	withShell(t, "/bin/sh")
+
+	arguments := jobArguments{
+		whole:   []string{"a b"},
+		columns: []string{"a b"},
+		named:   map[string]string{"q": "c d"},
+	}
+
+	// a column from --header, not the function - so it still needs quoting
+	if got, _ := replaceArgumentPlaceholders("{q}", arguments); got != "'c d'" {
+		t.Errorf("with --shell, replaceArgumentPlaceholders(\"{q}\") = %q; want %q", got, "'c d'")
+	}
+}
+
+func TestSplitUnescaped(t *testing.T) {
+	tests := []struct {
+		value string
+		n     int
+		want  []string
+	}{
+		{"a:b:c", -1, []string{"a", "b", "c"}},
+		{"a:b:c", 2, []string{"a", "b:c"}},
+		{"a:b:c", 1, []string{"a:b:c"}},
+		{"a\\:b:c", -1, []string{"a\\:b", "c"}},
+		{"a\\\\:b", -1, []string{"a\\\\", "b"}},
+		{"", -1, []string{""}},
+		{":", -1, []string{"", ""}},
+		{"trailing\\", -1, []string{"trailing\\"}},
+	}
+
+	for _, test := range tests {
+		got := splitUnescaped(test.value, ':', test.n)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("splitUnescaped(%q, ':', %d) = %q; want %q", test.value, test.n, got, test.want)
+		}
+	}
+}
+
+func TestApplyPlaceholderFunction(t *testing.T) {
+	tests := []struct {
+		expression string
+		value      string
+		want       string
+		wantOk     bool
+	}{
+		{"upper", "aB", "AB", true},
+		{"lower", "aB", "ab", true},
+		{"q", "it's", `'it'"'"'s'`, true},
+		{"q", "plain", "plain", true},
+		{"json", "a\"b\n", `"a\"b\n"`, true},
+		{"replace:a:b", "banana", "bbnbnb", true},
+		// the last argument takes up the rest, colons included
+		{"replace:a:b:c", "banana", "bb:cnb:cnb:c", true},
+		{"replace:\\::-", "a:b", "a-b", true},
+		{"replace:\\|:-", "a|b", "a-b", true},
+		{"regex:(\\d+)", "abc123def", "123", true},
+		{"regex:\\d+", "abc123def", "123", true},
+		{"regex:x", "abc", "", true},
+
+		{"nope", "a", "", false},
+		{"upper:x", "a", "", false},
+		{"replace", "a", "", false},
+		{"replace:a", "a", "", false},
+		{"regex", "a", "", false},
+	}
+
+	for _, test := range tests {
+		got, ok := applyPlaceholderFunction(test.expression, test.value)
+		if got != test.want || ok != test.wantOk {
+			t.Errorf("applyPlaceholderFunction(%q, %q) = %q, %v; want %q, %v",
+				test.expression, test.value, got, ok, test.want, test.wantOk)
+		}
+	}
+}
+
+func TestUsesPlaceholder(t *testing.T) {
+	tests := []struct {
+		command []string
+		content string
+		want    bool
+	}{
+		{[]string{"echo", "{##}"}, "##", true},
+		{[]string{"echo", "{#}"}, "##", false},
+		{[]string{"echo", "a{b{##}c}"}, "##", true},
+		{[]string{"echo"}, "##", false},
+	}
+
+	for _, test := range tests {
+		if got := usesPlaceholder(test.command, test.content); got != test.want {
+			t.Errorf("usesPlaceholder(%q, %q) = %v; want %v", test.command, test.content, got, test.want)
+		}
+	}
+}
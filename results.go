@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/alessio/shellescape"
+)
+
+// jobResults saves everything about a single job into its own directory for --results: DIR/<seq>/stdout, stderr,
+// cmd, exitcode and duration
+type jobResults struct {
+	dir string
+	// indexed by the file descriptor
+	files []*os.File
+}
+
+// startedJobs numbers jobs for --results in the order they were started in, the same as {#} does. With --unordered,
+// that's not necessarily the order their output gets displayed in
+var startedJobs int
+
+func newJobResults(command []string) *jobResults {
+	startedJobs += 1
+
+	results := &jobResults{
+		dir:   filepath.Join(*flResults, strconv.Itoa(startedJobs)),
+		files: make([]*os.File, len(standardFdToFile)),
+	}
+
+	if err := os.MkdirAll(results.dir, fs.ModePerm); err != nil {
+		log.Fatalf("Could not create directory %s for --results: %v\n", results.dir, err)
+	}
+
+	results.writeFile("cmd", shellescape.QuoteCommand(command)+"\n")
+	results.files[syscall.Stdout] = results.createFile("stdout")
+	results.files[syscall.Stderr] = results.createFile("stderr")
+
+	return results
+}
+
+func (results *jobResults) createFile(name string) *os.File {
+	file, err := os.Create(filepath.Join(results.dir, name))
+	if err != nil {
+		log.Fatalf("Could not create a file for --results: %v\n", err)
+	}
+	return file
+}
+
+func (results *jobResults) writeFile(name string, content string) {
+	if err := os.WriteFile(filepath.Join(results.dir, name), []byte(content), fs.ModePerm&^0o111); err != nil {
+		log.Fatalf("Could not write a file for --results: %v\n", err)
+	}
+}
+
+func (results *jobResults) write(data []byte, fileDescriptor int) {
+	if _, err := results.files[fileDescriptor].Write(data); err != nil {
+		log.Fatalf("Could not write to %s for --results: %v\n", results.files[fileDescriptor].Name(), err)
+	}
+}
+
+func (results *jobResults) finish(exitCode int, duration time.Duration) {
+	for _, file := range results.files {
+		if file != nil {
+			haveToClose(file.Name(), file)
+		}
+	}
+
+	results.writeFile("exitcode", strconv.Itoa(exitCode)+"\n")
+	results.writeFile("duration", fmt.Sprintf("%.3f\n", duration.Seconds()))
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"sync"
 	"syscall"
@@ -32,10 +33,21 @@ type Output struct {
 	winchSignal        chan os.Signal
 	streamClosed       chan struct{}
 	allocator          chunkAllocator
+	// indexed by the file descriptor, nil without --tag
+	taggers []*lineTagger
+	// nil without --results
+	results *jobResults
+	// everything that didn't fit in memory with --spill, nil until then
+	spilled *os.File
+	// nil without --max-output-per-job
+	limit *outputLimit
 }
 
 type ProcessResult struct {
+	// the number of the concurrency slot the process runs in, from 1 to -P
+	slot            int
 	startedAt       time.Time
+	finishedAt      time.Time
 	output          *Output
 	originalCommand []string
 	cmd             *exec.Cmd
@@ -60,17 +72,22 @@ func (proc *ProcessResult) wait() error {
 	defer recursiveTaskLimitClient().del(proc)
 
 	// wait for both stdout and stderr if we opened two readers
-	<-proc.output.streamClosed
-	if !stdoutAndStderrAreTheSame() {
+	for range proc.output.readingEnds() {
 		<-proc.output.streamClosed
 	}
 
+	if proc.output.limit != nil {
+		for _, chunk := range proc.output.limit.finish() {
+			proc.output.save(chunk.data, chunk.fileDescriptor)
+		}
+	}
+
 	signal.Stop(proc.output.winchSignal)
 
 	return proc.cmd.Wait()
 }
 
-func (out *Output) appendOrWrite(buf []byte, dataFromFd int) {
+func (out *Output) appendOrWrite(buf []byte, dataFromFd int, spill bool) {
 	out.partsMutex.Lock()
 	defer out.partsMutex.Unlock()
 
@@ -79,26 +96,62 @@ func (out *Output) appendOrWrite(buf []byte, dataFromFd int) {
 		if err != nil {
 			log.Fatalf("Syscall write to fd %d: %v\n", dataFromFd, err)
 		}
+	} else if spill {
+		out.spillChunk(byte(dataFromFd), buf)
+	} else if out.spilled != nil {
+		// once some output got spilled, everything after it has to be as well to keep it in order
+		mem.currentlyStored.Add(-chunkSizeWithHeader(buf))
+		out.spillChunk(byte(dataFromFd), buf)
 	} else {
 		out.appendChunk(byte(dataFromFd), buf)
 	}
 }
 
-func waitIfUsingTooMuchMemory(willSaveBytes int64, out *Output) {
+// spillsOverMaxMemory tells whether output over --max-mem goes to disk instead of blocking the job. --tee can't block
+// them: a blocked job stops reading its stdin, which holds up all the other jobs - the foreground one included
+func spillsOverMaxMemory() bool {
+	return *flSpill || *flTee
+}
+
+// waitIfUsingTooMuchMemory blocks until there's room to store more output in memory - or, with --spill, tells the
+// caller to put it on disk instead
+func waitIfUsingTooMuchMemory(willSaveBytes int64, out *Output) (spill bool) {
 	mem.childDiedFreeingMemory.L.Lock()
 	defer mem.childDiedFreeingMemory.L.Unlock()
 
 	if mem.currentlyInTheForeground == out {
-		return
+		if *flQuietSuccess {
+			// held on to until the job exits instead of passed through, so it still takes up memory - it just
+			// doesn't get blocked, as nothing else would free it up. It can still go to disk though
+			if spillsOverMaxMemory() && mem.currentlyStored.Load()+willSaveBytes > parsedFlMaxMemory {
+				return true
+			}
+			mem.currentlyStored.Add(willSaveBytes)
+		}
+		return false
+	}
+
+	if spillsOverMaxMemory() && mem.currentlyStored.Load()+willSaveBytes > parsedFlMaxMemory {
+		return true
 	}
 
 	mem.currentlyStored.Add(willSaveBytes)
-	for mem.currentlyStored.Load() > parsedFlMaxMemory {
+	for mem.currentlyStored.Load() > parsedFlMaxMemory && mem.currentlyInTheForeground != out {
 		//log.Printf("Blocking because we're storing %d MiB (here: %d)\n",
 		//	mem.currentlyStored.Load()/1024/1024,
 		//	len(out.parts)/1024/1024)
+		select {
+		case mem.someoneIsBlocked <- struct{}{}:
+		default:
+		}
 		mem.childDiedFreeingMemory.Wait()
 	}
+
+	if mem.currentlyInTheForeground == out && !*flQuietSuccess {
+		// got brought to the foreground while waiting, so the output is going to be passed through instead of saved
+		mem.currentlyStored.Add(-willSaveBytes)
+	}
+	return false
 }
 
 func readContinuouslyTo(stream io.ReadCloser, out *Output, fileDescriptor int) {
@@ -108,8 +161,14 @@ func readContinuouslyTo(stream io.ReadCloser, out *Output, fileDescriptor int) {
 		count, err := stream.Read(buffer)
 
 		if count > 0 {
-			waitIfUsingTooMuchMemory(chunkSizeWithHeader(buffer[:count]), out)
-			out.appendOrWrite(buffer[:count], fileDescriptor)
+			data := buffer[:count]
+			if out.results != nil {
+				out.results.write(data, fileDescriptor)
+			}
+			if out.taggers != nil {
+				data = out.taggers[fileDescriptor].tagLines(data)
+			}
+			out.saveWithinLimit(data, fileDescriptor)
 		}
 
 		if err != nil {
@@ -131,9 +190,47 @@ func readContinuouslyTo(stream io.ReadCloser, out *Output, fileDescriptor int) {
 		}
 	}
 
+	if out.taggers != nil {
+		out.saveWithinLimit(out.taggers[fileDescriptor].flush(nil), fileDescriptor)
+	}
+
 	out.streamClosed <- struct{}{}
 }
 
+func (out *Output) saveWithinLimit(data []byte, fileDescriptor int) {
+	if out.limit != nil {
+		data = out.limit.apply(data, fileDescriptor)
+	}
+	out.save(data, fileDescriptor)
+}
+
+// with --line-buffer, complete lines of every job get written out right away - one job at a time
+var lineBufferMutex sync.Mutex
+
+func writeLines(data []byte, fileDescriptor int) {
+	lineBufferMutex.Lock()
+	defer lineBufferMutex.Unlock()
+
+	_, err := standardFdToFile[fileDescriptor].Write(data)
+	if err != nil {
+		log.Fatalf("Syscall write to fd %d: %v\n", fileDescriptor, err)
+	}
+}
+
+func (out *Output) save(data []byte, fileDescriptor int) {
+	if len(data) == 0 {
+		return
+	}
+
+	if *flLineBuffer {
+		writeLines(data, fileDescriptor)
+		return
+	}
+
+	spill := waitIfUsingTooMuchMemory(chunkSizeWithHeader(data), out)
+	out.appendOrWrite(data, fileDescriptor, spill)
+}
+
 func haveToClose(name string, closer io.Closer) {
 	err := closer.Close()
 	if err != nil {
@@ -187,7 +284,8 @@ func createPty(winSize *ptyPkg.Winsize) (pty, tty *os.File, err error) {
 	return os.NewFile(uintptr(asyncPtyFd), "nonblocking /dev/ptmx"), tty, err
 }
 
-func runInteractive(cmd *exec.Cmd) *Output {
+// runInteractive starts cmd in a pty. The only error it returns is E2BIG, for the caller to split the command up
+func runInteractive(cmd *exec.Cmd) (*Output, error) {
 	cmd.Env = os.Environ()
 	if originalGoMaxProcs, exists := os.LookupEnv("GOMAXPROCS"); exists {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("_GPARALLEL_ORIGINAL_GOMAXPROCS=%s", originalGoMaxProcs))
@@ -202,26 +300,44 @@ func runInteractive(cmd *exec.Cmd) *Output {
 		log.Fatalf("Could not get terminal size: %v\n", err)
 	}
 
-	out.stdoutPipeOrPty, stdoutTty, err = createPty(size)
-	if err != nil {
-		log.Fatalf("Couldn't create a pty for %v's stdout: %v\n", cmd.Args, err)
+	// streams that already have somewhere to go, like a file from --output, don't get a pty
+	if cmd.Stdout == nil {
+		out.stdoutPipeOrPty, stdoutTty, err = createPty(size)
+		if err != nil {
+			log.Fatalf("Couldn't create a pty for %v's stdout: %v\n", cmd.Args, err)
+		}
+		defer haveToClose("stdout tty", stdoutTty)
+		cmd.Stdout = stdoutTty
 	}
-	defer haveToClose("stdout tty", stdoutTty)
 
-	if stdoutAndStderrAreTheSame() {
-		out.stderrPipeOrPty, stderrTty = out.stdoutPipeOrPty, stdoutTty
-	} else {
-		out.stderrPipeOrPty, stderrTty, err = createPty(size)
-		if err != nil {
-			log.Fatalf("Couldn't create a pty for %v's stderr: %v\n", cmd.Args, err)
+	if cmd.Stderr == nil {
+		if stdoutAndStderrAreTheSame() && stdoutTty != nil {
+			out.stderrPipeOrPty, stderrTty = out.stdoutPipeOrPty, stdoutTty
+		} else {
+			out.stderrPipeOrPty, stderrTty, err = createPty(size)
+			if err != nil {
+				log.Fatalf("Couldn't create a pty for %v's stderr: %v\n", cmd.Args, err)
+			}
+			defer haveToClose("stderr tty", stderrTty)
 		}
-		defer haveToClose("stderr tty", stderrTty)
+		cmd.Stderr = stderrTty
 	}
 
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setsid:  true,
-		Setctty: true,
-		Ctty:    1,
+	controllingTty, controllingFd := stdoutTty, 1
+	if controllingTty == nil {
+		controllingTty, controllingFd = stderrTty, 2
+	}
+
+	if controllingTty != nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Setsid:  true,
+			Setctty: true,
+			Ctty:    controllingFd,
+		}
+
+		if cmd.Stdin == nil {
+			cmd.Stdin = controllingTty
+		}
 	}
 
 	out.winchSignal = make(chan os.Signal, 1)
@@ -235,58 +351,83 @@ func runInteractive(cmd *exec.Cmd) *Output {
 				log.Fatalf("Could not get terminal size on sigwinch: %v\n", err)
 			}
 
-			_ = ptyPkg.Setsize(out.stdoutPipeOrPty, size)
-
-			if !stdoutAndStderrAreTheSame() {
-				_ = ptyPkg.Setsize(out.stderrPipeOrPty, size)
+			for _, pty := range out.readingEnds() {
+				_ = ptyPkg.Setsize(pty, size)
 			}
 		}
 	}()
 
-	if cmd.Stdin == nil {
-		cmd.Stdin = stdoutTty
-	}
-	cmd.Stdout = stdoutTty
-	cmd.Stderr = stderrTty
-
 	err = cmd.Start()
+	if errors.Is(err, syscall.E2BIG) {
+		signal.Stop(out.winchSignal)
+		close(out.winchSignal)
+		out.closeReadingEnds()
+		return nil, err
+	}
 	if err != nil {
 		// TODO: take the :2 only if --_execute-and-flush-tty is used - if not using it is even implemented
 		log.Fatalf("Could not start %v: %v\n", shellescape.QuoteCommand(cmd.Args[2:]), err)
 	}
 
-	return out
+	return out, nil
 }
 
-func runNonInteractive(cmd *exec.Cmd) *Output {
+func runNonInteractive(cmd *exec.Cmd) (*Output, error) {
 	var err error
 	var stdoutWritePipe, stderrWritePipe *os.File
 	out := &Output{}
 
-	out.stdoutPipeOrPty, stdoutWritePipe, err = os.Pipe()
-	if err != nil {
-		log.Fatalf("Could not create a pipe for %v's stdout: %v\n", cmd.Args, err)
+	// streams that already have somewhere to go, like a file from --output, don't get a pipe
+	if cmd.Stdout == nil {
+		out.stdoutPipeOrPty, stdoutWritePipe, err = os.Pipe()
+		if err != nil {
+			log.Fatalf("Could not create a pipe for %v's stdout: %v\n", cmd.Args, err)
+		}
+		defer haveToClose("stdout pipe", stdoutWritePipe)
+		cmd.Stdout = stdoutWritePipe
 	}
-	defer haveToClose("stdout pipe", stdoutWritePipe)
 
-	if stdoutAndStderrAreTheSame() {
-		out.stderrPipeOrPty, stderrWritePipe = out.stdoutPipeOrPty, stdoutWritePipe
-	} else {
-		out.stderrPipeOrPty, stderrWritePipe, err = os.Pipe()
-		if err != nil {
-			log.Fatalf("Could not create a pipe for %v's stderr: %v\n", cmd.Args, err)
+	if cmd.Stderr == nil {
+		if stdoutAndStderrAreTheSame() && stdoutWritePipe != nil {
+			out.stderrPipeOrPty, stderrWritePipe = out.stdoutPipeOrPty, stdoutWritePipe
+		} else {
+			out.stderrPipeOrPty, stderrWritePipe, err = os.Pipe()
+			if err != nil {
+				log.Fatalf("Could not create a pipe for %v's stderr: %v\n", cmd.Args, err)
+			}
+			defer haveToClose("stderr pipe", stderrWritePipe)
 		}
-		defer haveToClose("stderr pipe", stderrWritePipe)
+		cmd.Stderr = stderrWritePipe
 	}
 
-	cmd.Stdout = stdoutWritePipe
-	cmd.Stderr = stderrWritePipe
 	err = cmd.Start()
+	if errors.Is(err, syscall.E2BIG) {
+		out.closeReadingEnds()
+		return nil, err
+	}
 	if err != nil {
 		log.Fatalf("Could not start %v: %v\n", shellescape.QuoteCommand(cmd.Args), err)
 	}
 
-	return out
+	return out, nil
+}
+
+// readingEnds returns the pipes or ptys the output of a process gets read from: one for each of stdout and stderr,
+// unless they share one - or don't need one, as they go straight to a file
+func (out *Output) readingEnds() (ends []*os.File) {
+	if out.stdoutPipeOrPty != nil {
+		ends = append(ends, out.stdoutPipeOrPty)
+	}
+	if out.stderrPipeOrPty != nil && out.stderrPipeOrPty != out.stdoutPipeOrPty {
+		ends = append(ends, out.stderrPipeOrPty)
+	}
+	return ends
+}
+
+func (out *Output) closeReadingEnds() {
+	for _, end := range out.readingEnds() {
+		haveToClose("stdout or stderr pipe or pty", end)
+	}
 }
 
 // executable behaves like os.Executable(), but doesn't needlessly readlink the path, which is not necessary
@@ -306,49 +447,136 @@ func executable() string {
 	}
 }
 
-func runWithStdin(command []string, stdin io.Reader) (result *ProcessResult) {
+// job is a command to run, along with what to do with its output
+type job struct {
+	command []string
+	// what to prefix every line of output with for --tag, if anything
+	tag string
+	// files to write stdout and stderr to instead, for --output and --output-stderr
+	stdoutPath, stderrPath string
+}
+
+// createOutputFile creates a file for --output or --output-stderr, along with any directories it's supposed to be in
+func createOutputFile(path string) *os.File {
+	if err := os.MkdirAll(filepath.Dir(path), fs.ModePerm); err != nil {
+		log.Fatalf("Could not create directory %s for %s: %v\n", filepath.Dir(path), path, err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("Could not create output file %s: %v\n", path, err)
+	}
+	return file
+}
+
+// redirectOutput points stdout and stderr of a command at the files the job wants them in, if any
+func redirectOutput(cmd *exec.Cmd, job job) (files []*os.File) {
+	if job.stdoutPath != "" {
+		stdout := createOutputFile(job.stdoutPath)
+		cmd.Stdout = stdout
+		files = append(files, stdout)
+	}
+
+	if job.stderrPath != "" && job.stderrPath == job.stdoutPath {
+		// opening the file twice would make them overwrite each other
+		cmd.Stderr = cmd.Stdout
+	} else if job.stderrPath != "" {
+		stderr := createOutputFile(job.stderrPath)
+		cmd.Stderr = stderr
+		files = append(files, stderr)
+	}
+
+	return files
+}
+
+// runInSlot runs a job that can depend on the slot it ends up being run in - which is only known after waiting for
+// a free one. If the command turns out to be too long to run, it returns an error wrapping E2BIG
+func runInSlot(jobForSlot func(slot int) job, stdin io.Reader) (result *ProcessResult, err error) {
 	result = &ProcessResult{}
-	result.originalCommand = command
 	result.exitCode = make(chan int)
 
 	recursiveTaskLimitClient().addWait(result)
 
+	job := jobForSlot(result.slot)
+	command := job.command
+	result.originalCommand = command
+
 	if stdoutIsTty() {
 		command = append([]string{executable(), "--_execute-and-flush-tty"}, command...)
 	}
 
 	result.cmd = exec.Command(command[0], command[1:]...)
 	result.cmd.Stdin = stdin
+	outputFiles := redirectOutput(result.cmd, job)
 
 	if stdoutIsTty() {
-		result.output = runInteractive(result.cmd)
+		result.output, err = runInteractive(result.cmd)
 	} else {
-		result.output = runNonInteractive(result.cmd)
+		result.output, err = runNonInteractive(result.cmd)
+	}
+	for _, file := range outputFiles {
+		// the process has its own copy now
+		haveToClose(file.Name(), file)
+	}
+	if err != nil {
+		// give the slot back - it's not going to be used by anything
+		recursiveTaskLimitClient().del(result)
+		return nil, fmt.Errorf("%s: %w", shellescape.QuoteCommand(result.originalCommand), err)
 	}
 
 	result.output.streamClosed = make(chan struct{}, 2)
-	go readContinuouslyTo(result.output.stdoutPipeOrPty, result.output, syscall.Stdout)
-	if !stdoutAndStderrAreTheSame() {
+	if job.tag != "" || *flLineBuffer {
+		result.output.taggers = make([]*lineTagger, len(standardFdToFile))
+		result.output.taggers[syscall.Stdout] = newLineTagger(job.tag)
+		result.output.taggers[syscall.Stderr] = newLineTagger(job.tag)
+	}
+	if parsedFlMaxOutputPerJob > 0 {
+		result.output.limit = newOutputLimit()
+	}
+	if *flResults != "" {
+		result.output.results = newJobResults(result.originalCommand)
+	}
+	if result.output.stdoutPipeOrPty != nil {
+		go readContinuouslyTo(result.output.stdoutPipeOrPty, result.output, syscall.Stdout)
+	}
+	if result.output.stderrPipeOrPty != nil && result.output.stderrPipeOrPty != result.output.stdoutPipeOrPty {
 		go readContinuouslyTo(result.output.stderrPipeOrPty, result.output, syscall.Stderr)
 	}
 
 	result.startedAt = time.Now()
 
+	if *flLineBuffer && *flVerbose {
+		// output isn't going to wait for the job to get to the front, so neither does its command
+		writeLines([]byte(bold("+ "+shellescape.QuoteCommand(result.originalCommand))+"\n"), syscall.Stderr)
+	}
+
 	go func() {
 		err := result.wait()
 
 		// Check if our child exited unsuccessfully
+		exitCode := 0
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) {
-			result.exitCode <- exitErr.ExitCode()
-			return
-		}
-		if err != nil {
+			exitCode = exitErr.ExitCode()
+		} else if err != nil {
 			log.Fatalf("Failed to wait for command %s: %v\n", shellescape.QuoteCommand(command), err)
 		}
-		result.exitCode <- 0
+
+		result.finishedAt = time.Now()
+		if result.output.results != nil {
+			result.output.results.finish(exitCode, result.finishedAt.Sub(result.startedAt))
+		}
+		result.exitCode <- exitCode
 	}()
 
+	return result, nil
+}
+
+func runWithStdin(command []string, stdin io.Reader) (result *ProcessResult) {
+	result, err := runInSlot(func(int) job { return job{command: command} }, stdin)
+	if err != nil {
+		log.Fatalf("Could not start %v\n", err)
+	}
 	return result
 }
 
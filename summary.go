@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alessio/shellescape"
+	"golang.org/x/exp/slices"
+)
+
+type finishedJob struct {
+	command  []string
+	exitCode int
+	duration time.Duration
+	// output over --max-output-per-job
+	droppedBytes int64
+}
+
+// runSummary collects what --summary reports once everything's done
+type runSummary struct {
+	mutex     sync.Mutex
+	startedAt time.Time
+	finished  []finishedJob
+	failed    []finishedJob
+	// jobs not run to completion because another one failed - either terminated, or never started at all
+	skipped int
+}
+
+var summary = runSummary{startedAt: time.Now()}
+
+func (s *runSummary) jobFinished(proc *ProcessResult, exitCode int) {
+	job := finishedJob{
+		command:  proc.originalCommand,
+		exitCode: exitCode,
+		duration: proc.finishedAt.Sub(proc.startedAt),
+	}
+	if limit := proc.output.limit; limit != nil {
+		limit.mutex.Lock()
+		job.droppedBytes = limit.dropped
+		limit.mutex.Unlock()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.finished = append(s.finished, job)
+	if exitCode != 0 {
+		s.failed = append(s.failed, job)
+	}
+}
+
+// jobStoppedAfterFailure counts a job that got terminated because another one failed - unless it managed to exit on
+// its own before that, and so did run to completion
+func (s *runSummary) jobStoppedAfterFailure(proc *ProcessResult, exitCode int) {
+	if exitCode != -1 {
+		s.jobFinished(proc, exitCode)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.skipped += 1
+}
+
+// jobsNotStarted counts jobs that don't get started at all because another one failed. Going through the rest of
+// the input just to count them is only worth it for --summary, which keepCounting tells
+func (s *runSummary) jobsNotStarted(count int) (keepCounting bool) {
+	if *flSummary < 0 {
+		return false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.skipped += count
+	return true
+}
+
+func (s *runSummary) print(slowest int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, _ = fmt.Fprintf(os.Stderr, "\n"+bold("Summary:")+" %d succeeded, %d failed, %d skipped, took %v\n",
+		len(s.finished)-len(s.failed),
+		len(s.failed),
+		s.skipped,
+		time.Since(s.startedAt).Round(time.Millisecond))
+
+	bySlowest := slices.Clone(s.finished)
+	slices.SortStableFunc(bySlowest, func(a, b finishedJob) int {
+		switch {
+		case a.duration > b.duration:
+			return -1
+		case a.duration < b.duration:
+			return 1
+		}
+		return 0
+	})
+	if len(bySlowest) > slowest {
+		bySlowest = bySlowest[:slowest]
+	}
+
+	if len(bySlowest) > 0 {
+		_, _ = fmt.Fprintf(os.Stderr, bold("Slowest jobs:")+"\n")
+		for _, job := range bySlowest {
+			_, _ = fmt.Fprintf(os.Stderr, "  %-10v %s\n", job.duration.Round(time.Millisecond), shellescape.QuoteCommand(job.command))
+		}
+	}
+
+	if len(s.failed) > 0 {
+		_, _ = fmt.Fprintf(os.Stderr, bold("Failed jobs:")+"\n")
+		for _, job := range s.failed {
+			_, _ = fmt.Fprintf(os.Stderr, "  exit code %-3d %s\n", job.exitCode, shellescape.QuoteCommand(job.command))
+		}
+	}
+
+	truncated := false
+	for _, job := range s.finished {
+		if job.droppedBytes == 0 {
+			continue
+		}
+		if !truncated {
+			_, _ = fmt.Fprintf(os.Stderr, bold("Output dropped over --max-output-per-job:")+"\n")
+			truncated = true
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "  %-10s %s\n", fmt.Sprintf("%d bytes", job.droppedBytes), shellescape.QuoteCommand(job.command))
+	}
+}
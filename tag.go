@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"time"
+)
+
+// lineTagger prefixes every line of one stream of a job's output with the job's tag. A line only gets written out
+// once it's complete, so that it can't get mixed up with another stream of the same job - or, with --line-buffer,
+// with other jobs. That's also why --line-buffer uses it even without a tag
+type lineTagger struct {
+	tag       string
+	startedAt time.Time
+	pending   []byte
+}
+
+func newLineTagger(tag string) *lineTagger {
+	return &lineTagger{tag: tag, startedAt: time.Now()}
+}
+
+func (tagger *lineTagger) prefix() string {
+	if !strings.Contains(tagger.tag, "elapsed") {
+		return tagger.tag
+	}
+
+	prefix, _ := replacePlaceholders(tagger.tag, func(content string) (string, bool) {
+		if content != "elapsed" {
+			return "", false
+		}
+		return time.Since(tagger.startedAt).Round(time.Millisecond).String(), true
+	})
+	return prefix
+}
+
+// tagLines returns every line completed by data, prefixed with the tag. A line that isn't complete yet stays
+// pending until the rest of it arrives - unless it gets too long to keep holding on to, and there are no other jobs
+// to mix it up with
+func (tagger *lineTagger) tagLines(data []byte) (tagged []byte) {
+	for len(data) > 0 {
+		newline := bytes.IndexByte(data, '\n')
+		if newline == -1 {
+			tagger.pending = append(tagger.pending, data...)
+			if len(tagger.pending) >= MAXBUF && !*flLineBuffer {
+				tagged = tagger.flush(tagged)
+			}
+			break
+		}
+
+		tagged = append(tagged, tagger.prefix()...)
+		tagged = append(tagged, tagger.pending...)
+		tagged = append(tagged, data[:newline+1]...)
+		tagger.pending = tagger.pending[:0]
+		data = data[newline+1:]
+	}
+
+	return tagged
+}
+
+// flush appends whatever's pending, for when the stream ends without a final newline. With --line-buffer, the line
+// gets finished off with one, so that the next job's output doesn't continue it
+func (tagger *lineTagger) flush(tagged []byte) []byte {
+	if len(tagger.pending) == 0 {
+		return tagged
+	}
+
+	tagged = append(tagged, tagger.prefix()...)
+	tagged = append(tagged, tagger.pending...)
+	if *flLineBuffer {
+		tagged = append(tagged, '\n')
+	}
+	tagger.pending = tagger.pending[:0]
+	return tagged
+}
+
+// tagForJob fills in --tag-string for a job. Only {elapsed} is left for later, as it's different for every line
+func tagForJob(batch []jobArguments) string {
+	if !*flTag && *flTagString == "" {
+		return ""
+	}
+
+	template := *flTagString
+	if template == "" {
+		template = *flTemplate
+	}
+
+	return fillTemplate(template, batch) + "\t"
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// teeTo copies everything from input to every one of the outputs. Writes block until every job takes its copy, so
+// the slowest one sets the pace instead of us buffering for it
+func teeTo(input io.Reader, outputs []*os.File) {
+	buffer := make([]byte, 64*1024)
+
+	for len(outputs) > 0 {
+		n, err := input.Read(buffer)
+
+		stillReading := outputs[:0]
+		for _, output := range outputs {
+			if _, err := output.Write(buffer[:n]); err != nil {
+				// the job has exited or closed its stdin, so it doesn't want any more of it
+				_ = output.Close()
+				continue
+			}
+			stillReading = append(stillReading, output)
+		}
+		outputs = stillReading
+
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				_, _ = fmt.Fprintf(os.Stderr, "%s: Warning: could not read stdin for --tee: %v\n", os.Args[0], err)
+			}
+			break
+		}
+	}
+
+	for _, output := range outputs {
+		_ = output.Close()
+	}
+}
+
+// startProcessesWithTee runs every job at once, each of them with a copy of the whole stdin
+func startProcessesWithTee(command []string, input io.Reader, forEachBatch func(fn func(batch []jobArguments) (keepGoing bool)), result chan<- *ProcessResult) {
+	var batches [][]jobArguments
+	forEachBatch(func(batch []jobArguments) (keepGoing bool) {
+		batches = append(batches, batch)
+		return true
+	})
+
+	if len(batches) > *flMaxProcesses {
+		log.Fatalf("--tee has to run all %d jobs at once, but -P (--max-concurrent) only allows %d - pass -P %d to allow it\n",
+			len(batches), *flMaxProcesses, len(batches))
+	}
+
+	stdins := make([]*os.File, len(batches))
+	pipeWriters := make([]*os.File, len(batches))
+	for i := range batches {
+		var err error
+		stdins[i], pipeWriters[i], err = os.Pipe()
+		if err != nil {
+			log.Fatalf("Could not create a pipe for --tee: %v\n", err)
+		}
+	}
+
+	go teeTo(input, pipeWriters)
+
+	for i, batch := range batches {
+		if noLongerSpawnChildren.Load() {
+			// nobody's going to read from the rest
+			for _, stdin := range stdins[i:] {
+				_ = stdin.Close()
+			}
+			summary.jobsNotStarted(len(batches) - i)
+			return
+		}
+
+		process, err := runInSlot(func(slot int) job {
+			for j := range batch {
+				batch[j].sequence, batch[j].slot, batch[j].total = i+1, slot, len(batches)
+			}
+			return newJob(command, batch)
+		}, stdins[i])
+		if err != nil {
+			log.Fatalf("Could not start %v\n", err)
+		}
+
+		// the job has its own copy of the descriptor now
+		_ = stdins[i].Close()
+
+		result <- process
+	}
+}
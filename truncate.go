@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// outputLimit cuts the output of a single job down to --max-output-per-job, keeping its head, its tail, or both of
+// them - with a note about how much got dropped in place of what's missing
+type outputLimit struct {
+	mutex sync.Mutex
+	// how much more output can be let through as the head
+	headLeft int64
+	// whether the head got cut in the middle of a line, so that the note needs to start on a new one
+	headEndsMidLine bool
+	// how much output to hold on to as the tail, which only gets let through once the job is done
+	tailSize  int64
+	tail      []outputChunk
+	tailBytes int64
+	dropped   int64
+}
+
+type outputChunk struct {
+	fileDescriptor int
+	data           []byte
+}
+
+func newOutputLimit() *outputLimit {
+	limit := &outputLimit{}
+
+	switch *flMaxOutputPolicy {
+	case "head":
+		limit.headLeft = parsedFlMaxOutputPerJob
+	case "tail":
+		limit.tailSize = parsedFlMaxOutputPerJob
+	case "both":
+		limit.headLeft = parsedFlMaxOutputPerJob / 2
+		limit.tailSize = parsedFlMaxOutputPerJob - limit.headLeft
+	}
+
+	return limit
+}
+
+// apply returns the part of data that can be let through right away - the rest is either dropped, or held on to in
+// case it ends up in the tail
+func (limit *outputLimit) apply(data []byte, fileDescriptor int) []byte {
+	limit.mutex.Lock()
+	defer limit.mutex.Unlock()
+
+	head := data
+	if int64(len(head)) > limit.headLeft {
+		head = data[:limit.headLeft]
+	}
+	limit.headLeft -= int64(len(head))
+	if len(head) > 0 {
+		limit.headEndsMidLine = head[len(head)-1] != '\n'
+	}
+
+	if rest := data[len(head):]; len(rest) > 0 {
+		limit.keepInTail(rest, fileDescriptor)
+	}
+
+	return head
+}
+
+func (limit *outputLimit) keepInTail(data []byte, fileDescriptor int) {
+	limit.tail = append(limit.tail, outputChunk{fileDescriptor, append([]byte(nil), data...)})
+	limit.tailBytes += int64(len(data))
+
+	for limit.tailBytes > limit.tailSize {
+		excess := limit.tailBytes - limit.tailSize
+		oldest := &limit.tail[0]
+
+		if int64(len(oldest.data)) <= excess {
+			excess = int64(len(oldest.data))
+			limit.tail = limit.tail[1:]
+		} else {
+			oldest.data = oldest.data[excess:]
+		}
+
+		limit.tailBytes -= excess
+		limit.dropped += excess
+	}
+}
+
+// finish returns what's left to be let through once the job is done: the note about dropped output, and the tail
+func (limit *outputLimit) finish() (chunks []outputChunk) {
+	limit.mutex.Lock()
+	defer limit.mutex.Unlock()
+
+	if limit.dropped > 0 {
+		note := fmt.Sprintf("%s: Warning: dropped %d bytes of output over --max-output-per-job\n", os.Args[0], limit.dropped)
+		if limit.headEndsMidLine {
+			note = "\n" + note
+		}
+		chunks = append(chunks, outputChunk{syscall.Stderr, []byte(note)})
+	}
+
+	chunks = append(chunks, limit.tail...)
+	limit.tail, limit.tailBytes = nil, 0
+	return chunks
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/alessio/shellescape"
+	"golang.org/x/exp/slices"
+)
+
+type finishedProcess struct {
+	process  *ProcessResult
+	exitCode int
+}
+
+// displayInCompletionOrder writes out the whole output of every job as soon as it finishes. Only when the memory
+// limit gets hit does the oldest job still running get brought to the foreground, the way displaySequentially does
+// it - and jobs finishing in the meantime have to wait for it, so that outputs don't get mixed up
+func displayInCompletionOrder(processes <-chan *ProcessResult) (exitCode int) {
+	finished := make(chan finishedProcess)
+	var running []*ProcessResult
+	var inTheForeground *ProcessResult
+	var waitingForForeground []finishedProcess
+	failed := false
+
+	display := func(done finishedProcess) {
+		if done.process != inTheForeground || *flQuietSuccess {
+			reportFinished(done.process, done.exitCode)
+		}
+		summary.jobFinished(done.process, done.exitCode)
+
+		exitCode = max(exitCode, done.exitCode)
+		failed = failed || (done.exitCode != 0 && !*flKeepGoingOnError)
+	}
+
+	for !failed && (processes != nil || len(running) > 0) {
+		select {
+		case process, ok := <-processes:
+			if !ok {
+				processes = nil
+				continue
+			}
+
+			running = append(running, process)
+			go func() {
+				finished <- finishedProcess{process, <-process.exitCode}
+			}()
+
+		case done := <-finished:
+			index := slices.Index(running, done.process)
+			running = slices.Delete(running, index, index+1)
+
+			if inTheForeground != nil && done.process != inTheForeground {
+				waitingForForeground = append(waitingForForeground, done)
+				continue
+			}
+
+			display(done)
+			if done.process == inTheForeground {
+				inTheForeground = nil
+				for _, waiting := range waitingForForeground {
+					display(waiting)
+				}
+				waitingForForeground = nil
+
+				// the job that just finished didn't free up any memory, as its output wasn't stored anywhere.
+				// Another one has to be brought to the foreground if there are still outputs waiting
+				wakeUpBlockedOutputs()
+			}
+
+		case <-mem.someoneIsBlocked:
+			if inTheForeground != nil || len(running) == 0 {
+				continue
+			}
+
+			inTheForeground = running[0]
+			if *flQuietSuccess {
+				holdInForeground(inTheForeground)
+				continue
+			}
+
+			if *flVerbose && !*flLineBuffer {
+				_, _ = fmt.Fprintf(os.Stderr, bold("+ %s")+"\n", shellescape.QuoteCommand(inTheForeground.originalCommand))
+			}
+			bringToForeground(inTheForeground)
+		}
+	}
+
+	if failed {
+		noLongerSpawnChildren.Store(true)
+
+		for _, process := range running {
+			_ = process.cmd.Process.Signal(syscall.SIGTERM)
+		}
+		if processes != nil {
+			waitForChildrenAfterAFailedOne(processes)
+		}
+		for range running {
+			done := <-finished
+			summary.jobStoppedAfterFailure(done.process, done.exitCode)
+		}
+	}
+
+	return exitCode
+}